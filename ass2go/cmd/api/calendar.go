@@ -0,0 +1,103 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/ical"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// calendarTokenTTL is how long a calendar token stays valid. Calendar apps keep polling
+// the feed for as long as it is subscribed to, so the token has to be long-lived.
+const calendarTokenTTL = 365 * 24 * time.Hour
+
+func (app *application) moduleExamsCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	module, err := app.models.InfoModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	exams, err := app.models.ExamSessions.GetScheduleForModule(module.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeCalendar(w, r, fmt.Sprintf("%s exams", module.ModuleName), exams)
+}
+
+func (app *application) userExamsCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	exams, err := app.models.ExamSessions.GetScheduleForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeCalendar(w, r, "My exams", exams)
+}
+
+// writeCalendar sends the exam sessions as an iCalendar feed, one VEVENT per session.
+func (app *application) writeCalendar(w http.ResponseWriter, r *http.Request, name string, exams []*data.ScheduledExam) {
+	calendar := ical.Calendar{ProductID: "-//ass2//Exam Schedule//EN", Name: name}
+	for _, exam := range exams {
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:         fmt.Sprintf("exam-session-%d@%s", exam.ID, r.Host),
+			Start:       exam.StartsAt,
+			End:         exam.EndsAt,
+			Summary:     fmt.Sprintf("%s exam", exam.ModuleName),
+			Location:    exam.Room,
+			Description: fmt.Sprintf("Exam for module %d", exam.ModuleInfoID),
+			Modified:    exam.UpdatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(calendar.Encode())
+}
+
+// rotateCalendarTokenHandler issues a new calendar token for the user, revoking any
+// previous one so that only the latest feed URL keeps working.
+func (app *application) rotateCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeCalendar, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, calendarTokenTTL, data.ScopeCalendar)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	feedURL := "/v1/users/me/calendar.ics?token=" + url.QueryEscape(token.Plaintext)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"calendar_token": token, "feed_url": feedURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeCalendar, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "calendar token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
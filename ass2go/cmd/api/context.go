@@ -32,3 +32,40 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+const apiKeyContextKey = contextKey("apiKey")
+
+// The contextSetAPIKey() method returns a new copy of the request with the API key it
+// was authenticated with added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() method retrieves the API key the request was authenticated
+// with, or nil if it wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+const formatContextKey = contextKey("format")
+
+// The contextSetFormat() method returns a new copy of the request with the negotiated
+// response format added to the context.
+func (app *application) contextSetFormat(r *http.Request, format string) *http.Request {
+	ctx := context.WithValue(r.Context(), formatContextKey, format)
+	return r.WithContext(ctx)
+}
+
+// The contextGetFormat() method retrieves the negotiated response format from the
+// request context. Unlike the user, it falls back to JSON if there isn't one, since
+// responses can be written before negotiateContent() has run, for example by the
+// recoverPanic() middleware.
+func (app *application) contextGetFormat(r *http.Request) string {
+	format, ok := r.Context().Value(formatContextKey).(string)
+	if !ok {
+		return formatJSON
+	}
+	return format
+}
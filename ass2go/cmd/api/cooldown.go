@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// cooldown limits how often an action can be taken for the same key, such as sending an
+// email to the same address. Keys are compared case-insensitively.
+type cooldown struct {
+	mu        sync.Mutex
+	period    time.Duration
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newCooldown(period time.Duration) *cooldown {
+	return &cooldown{
+		period:    period,
+		last:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether the action can be taken for the key now, and if so starts a new
+// cooldown period for it. Otherwise it returns how long is left of the current period.
+func (c *cooldown) allow(key string) (bool, time.Duration) {
+	key = strings.ToLower(key)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget the keys whose cooldown has run out every so often, so that the map
+	// doesn't keep growing.
+	if now.Sub(c.lastSweep) > c.period {
+		for k, t := range c.last {
+			if now.Sub(t) >= c.period {
+				delete(c.last, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if last, ok := c.last[key]; ok && now.Sub(last) < c.period {
+		return false, c.period - now.Sub(last)
+	}
+	c.last[key] = now
+	return true, 0
+}
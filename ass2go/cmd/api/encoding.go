@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+	formatYAML = "yaml"
+)
+
+// mediaTypeFormats maps the media types we can respond with to the encoding used for
+// them. Wildcards fall back to JSON, which is the default.
+var mediaTypeFormats = map[string]string{
+	"*/*":                formatJSON,
+	"application/*":      formatJSON,
+	"application/json":   formatJSON,
+	"application/xml":    formatXML,
+	"text/xml":           formatXML,
+	"application/yaml":   formatYAML,
+	"application/x-yaml": formatYAML,
+	"text/yaml":          formatYAML,
+}
+
+var formatContentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatXML:  "application/xml; charset=utf-8",
+	formatYAML: "application/yaml; charset=utf-8",
+}
+
+// negotiateFormat picks the response encoding for an Accept header, honouring the
+// quality values in it. An empty header means the client accepts anything. The second
+// return value is false if none of the media types in the header can be served.
+func negotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+	return negotiateMediaType(accept, mediaTypeFormats)
+}
+
+// negotiateMediaType picks the format, out of those the given media types map to, which
+// the client prefers according to the quality values in the Accept header. Media types
+// missing from the map, or given a quality of 0, are skipped. The second return value is
+// false if that leaves nothing.
+func negotiateMediaType(accept string, formats map[string]string) (string, bool) {
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := formats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{format, q})
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	// A stable sort keeps the order the client listed equally weighted types in.
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].format, true
+}
+
+// encode marshals the envelope in the given format. XML and YAML are produced from the
+// JSON encoding of the envelope, so that every format uses the same field names and
+// leaves out the same fields as the JSON one.
+func encode(format string, data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if format == formatJSON {
+		return append(js, '\n'), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var value any
+	err = dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case formatXML:
+		return encodeXML(value)
+	default:
+		return yaml.Marshal(normalizeNumbers(value))
+	}
+}
+
+// normalizeNumbers turns the json.Number values in a decoded JSON document back into
+// integers or floats, so that they're written as numbers rather than strings.
+func normalizeNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return value
+}
+
+// encodeXML writes a decoded JSON document as XML under a <response> root element.
+// Objects become elements named after their keys, in sorted order, and the items of an
+// array are written as repeated <item> elements. Keys which aren't valid XML names,
+// such as some validation error keys, are written as <entry key="..."> instead. Nulls
+// are written as empty elements with a nil="true" attribute.
+func encodeXML(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+	err := encodeXMLElement(enc, "response", value)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func encodeXMLElement(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start.Name.Local = "entry"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "key"}, Value: name})
+	}
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			err = encodeXMLElement(enc, key, v[key])
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			err = encodeXMLElement(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+	case json.Number:
+		err = enc.EncodeToken(xml.CharData(v.String()))
+	case bool:
+		err = enc.EncodeToken(xml.CharData(strconv.FormatBool(v)))
+	case string:
+		err = enc.EncodeToken(xml.CharData(v))
+	}
+	if err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether s can be used as an element name as it is. This is a
+// conservative subset of the XML name production, and excludes names starting with
+// "xml", which are reserved.
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// routeSet is a set of routes, each given as a method and a path pattern in httprouter
+// syntax, where a :name segment matches any single path segment.
+type routeSet map[string][][]string
+
+func (s routeSet) add(method, pattern string) {
+	s[method] = append(s[method], strings.Split(pattern, "/"))
+}
+
+func (s routeSet) contains(method, path string) bool {
+	segments := strings.Split(path, "/")
+	for _, pattern := range s[method] {
+		if routeMatches(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeMatches(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, p := range pattern {
+		if strings.HasPrefix(p, ":") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != segments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", formatJSON, true},
+		{"   ", formatJSON, true},
+		{"application/json", formatJSON, true},
+		{"application/xml", formatXML, true},
+		{"text/xml", formatXML, true},
+		{"application/yaml", formatYAML, true},
+		{"application/x-yaml", formatYAML, true},
+		{"text/yaml", formatYAML, true},
+		{"*/*", formatJSON, true},
+		{"application/*", formatJSON, true},
+		{"application/xml; charset=utf-8", formatXML, true},
+		{"APPLICATION/XML", formatXML, true},
+		// The highest quality value wins, whatever the order.
+		{"application/json;q=0.5, application/xml", formatXML, true},
+		{"application/xml;q=0.1, application/yaml;q=0.9, application/json;q=0.5", formatYAML, true},
+		// Equally weighted types are taken in the order they are listed.
+		{"application/yaml, application/xml", formatYAML, true},
+		{"application/xml;q=0.8, application/yaml;q=0.8", formatXML, true},
+		// A quality of 0 means not acceptable.
+		{"application/xml;q=0, application/json", formatJSON, true},
+		{"application/xml;q=0", "", false},
+		// Unknown and malformed entries are skipped.
+		{"text/html, application/xml", formatXML, true},
+		{"application/xml;q=high, application/yaml", formatYAML, true},
+		{";;;, application/yaml", formatYAML, true},
+		{"text/html", "", false},
+		{"text/csv, image/png;q=0.9", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := negotiateFormat(tt.accept)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("negotiateFormat(%q) = %q, %t, want %q, %t", tt.accept, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNegotiateMediaTypeExport(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"text/csv", exportFormatCSV, true},
+		{"application/x-ndjson", exportFormatNDJSON, true},
+		{"text/csv;q=0, application/x-ndjson", exportFormatNDJSON, true},
+		{"application/x-ndjson;q=0.5, text/csv", exportFormatCSV, true},
+		{"text/csv;q=0.5, */*", exportFormatNDJSON, true},
+		{"text/csv;q=0", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := negotiateMediaType(tt.accept, exportMediaTypeFormats)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("negotiateMediaType(%q) = %q, %t, want %q, %t", tt.accept, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// xmlHeader is the declaration every XML response starts with.
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+func TestEncodeXML(t *testing.T) {
+	data := envelope{
+		"errors": map[string]string{
+			"semesters[0]": "must only contain existing modules",
+			"xmlns":        "reserved",
+		},
+		"module_info": map[string]any{
+			"id":         1,
+			"name":       "Go & <Rust>",
+			"deleted_at": nil,
+			"activated":  true,
+			"ratio":      0.5,
+			"tags":       []string{"a", "b"},
+			"empty":      []string{},
+		},
+	}
+	want := xmlHeader + `<response>
+	<errors>
+		<entry key="semesters[0]">must only contain existing modules</entry>
+		<entry key="xmlns">reserved</entry>
+	</errors>
+	<module_info>
+		<activated>true</activated>
+		<deleted_at nil="true"></deleted_at>
+		<empty></empty>
+		<id>1</id>
+		<name>Go &amp; &lt;Rust&gt;</name>
+		<ratio>0.5</ratio>
+		<tags>
+			<item>a</item>
+			<item>b</item>
+		</tags>
+	</module_info>
+</response>
+`
+	got, err := encode(formatXML, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEncodeXMLTopLevelValues(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{nil, `<response nil="true"></response>`},
+		{"text", `<response>text</response>`},
+		{false, `<response>false</response>`},
+		{[]any{}, `<response></response>`},
+	}
+	for _, tt := range tests {
+		got, err := encodeXML(tt.value)
+		if err != nil {
+			t.Fatalf("encodeXML(%v): unexpected error: %v", tt.value, err)
+		}
+		if want := xmlHeader + tt.want + "\n"; string(got) != want {
+			t.Errorf("encodeXML(%v) = %q, want %q", tt.value, got, want)
+		}
+	}
+}
+
+func TestIsXMLName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"module_info", true},
+		{"Module", true},
+		{"_private", true},
+		{"a1", true},
+		{"a-b.c", true},
+		{"", false},
+		{"1a", false},
+		{"-a", false},
+		{".a", false},
+		{"a b", false},
+		{"a:b", false},
+		{"semesters[0]", false},
+		{"xml", false},
+		{"XMLData", false},
+		{"xmlns", false},
+		{"émile", false},
+	}
+	for _, tt := range tests {
+		if got := isXMLName(tt.name); got != tt.want {
+			t.Errorf("isXMLName(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRouteSetContains(t *testing.T) {
+	routes := routeSet{}
+	routes.add("GET", "/v1/info/export")
+	routes.add("GET", "/v1/info/:id/exams.ics")
+	routes.add("POST", "/v1/users/:id/tokens/:kind")
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/v1/info/export", true},
+		{"GET", "/v1/info/12/exams.ics", true},
+		{"GET", "/v1/info/abc/exams.ics", true},
+		{"POST", "/v1/users/1/tokens/calendar", true},
+		// A parameter matches exactly one non-empty segment.
+		{"GET", "/v1/info//exams.ics", false},
+		{"GET", "/v1/info/1/2/exams.ics", false},
+		{"POST", "/v1/users/1/tokens/", false},
+		// Everything else has to match exactly.
+		{"HEAD", "/v1/info/export", false},
+		{"POST", "/v1/info/export", false},
+		{"GET", "/v1/info/export/", false},
+		{"GET", "/v1/info/exports", false},
+		{"GET", "/V1/info/export", false},
+		{"GET", "/v1/info/12/exams.json", false},
+		{"GET", "/v1/info", false},
+		{"GET", "/", false},
+		{"GET", "", false},
+	}
+	for _, tt := range tests {
+		if got := routes.contains(tt.method, tt.path); got != tt.want {
+			t.Errorf("contains(%s, %q) = %t, want %t", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/", true},
+		{"/:id", "/1", true},
+		{"/:id", "/", false},
+		{"/a/:b/c/:d", "/a/x/c/y", true},
+		{"/a/:b/c/:d", "/a/x/d/y", false},
+		{"/a", "/a/", false},
+	}
+	for _, tt := range tests {
+		got := routeMatches(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/"))
+		if got != tt.want {
+			t.Errorf("routeMatches(%q, %q) = %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
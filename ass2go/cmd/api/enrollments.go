@@ -0,0 +1,111 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"errors"
+	"net/http"
+)
+
+func (app *application) enrollHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	enrollment, err := app.models.Enrollments.Enroll(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAlreadyEnrolled):
+			app.alreadyEnrolledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"enrollment": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) withdrawHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	promoted, err := app.models.Enrollments.Withdraw(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if promoted != nil {
+		app.notifyPromotion(promoted)
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "successfully withdrawn from module"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listEnrollmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.InfoModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	enrollments, err := app.models.Enrollments.GetAllForModule(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"enrollments": enrollments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyPromotion emails a student who has just been moved off a waitlist. It runs in
+// the background, so the request which freed up the place doesn't wait on the mail
+// server.
+func (app *application) notifyPromotion(enrollment *data.Enrollment) {
+	app.background(func() {
+		user, err := app.models.Users.Get(enrollment.UserID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+		module, err := app.models.InfoModel.Get(enrollment.ModuleInfoID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+		data := map[string]any{
+			"moduleID":   module.ID,
+			"moduleName": module.ModuleName,
+		}
+		err = app.mailer.Send(user.Email, "enrollment_promoted.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
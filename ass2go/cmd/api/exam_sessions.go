@@ -0,0 +1,262 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// examSessionInput is the request body accepted when creating or replacing an exam
+// session.
+type examSessionInput struct {
+	ModuleInfoID int64     `json:"module_info_id"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	Room         string    `json:"room"`
+	ProctorID    int64     `json:"proctor_id"`
+}
+
+func (input examSessionInput) apply(session *data.ExamSession) {
+	session.ModuleInfoID = input.ModuleInfoID
+	session.StartsAt = input.StartsAt
+	session.EndsAt = input.EndsAt
+	session.Room = input.Room
+	session.ProctorID = &input.ProctorID
+}
+
+// checkExamSession validates the session and makes sure that its module and proctor
+// exist, then looks for overlapping sessions of modules which share enrolled students
+// with it. It writes an error response and returns false if the session can't be saved,
+// which includes when there are student conflicts and the request asked for them to be
+// rejected with ?strict=true.
+func (app *application) checkExamSession(w http.ResponseWriter, r *http.Request, session *data.ExamSession) ([]*data.ExamConflict, bool) {
+	v := validator.New()
+	strict := app.readBool(r.URL.Query(), "strict", false, v)
+	if data.ValidateExamSession(v, session); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	_, err := app.models.InfoModel.Get(session.ModuleInfoID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("module_info_id", "must refer to an existing module")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+	}
+	_, err = app.models.Users.Get(*session.ProctorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("proctor_id", "must refer to an existing user")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	conflicts, err := app.models.ExamSessions.StudentConflicts(session)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if strict && len(conflicts) > 0 {
+		app.studentConflictResponse(w, r, conflicts)
+		return nil, false
+	}
+	return conflicts, true
+}
+
+func (app *application) createExamSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input examSessionInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	session := &data.ExamSession{}
+	input.apply(session)
+	conflicts, ok := app.checkExamSession(w, r, session)
+	if !ok {
+		return
+	}
+	err = app.models.ExamSessions.Insert(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRoomConflict):
+			app.roomConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/exam-sessions/%d", session.ID))
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"exam_session": session, "warnings": conflicts}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showExamSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	session, err := app.models.ExamSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(session.ID, session.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"exam_session": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listExamSessionsHandler lists the exam sessions overlapping the period between the
+// from and to query parameters, which default to the coming year.
+func (app *application) listExamSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ModuleInfoID int
+		From         time.Time
+		To           time.Time
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	now := time.Now()
+	input.ModuleInfoID = app.readInt(qs, "module_id", 0, v)
+	input.From = app.readTime(qs, "from", now, v)
+	input.To = app.readTime(qs, "to", now.AddDate(1, 0, 0), v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "starts_at")
+	input.Filters.SortSafelist = []string{"id", "starts_at", "room", "-id", "-starts_at", "-room"}
+	v.Check(input.To.After(input.From), "to", "must be after from")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	sessions, metadata, err := app.models.ExamSessions.GetAll(int64(input.ModuleInfoID), input.From, input.To, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"exam_sessions": sessions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateExamSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	session, err := app.models.ExamSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.ifMatch(r, app.etag(session.ID, session.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	var input examSessionInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	input.apply(session)
+	conflicts, ok := app.checkExamSession(w, r, session)
+	if !ok {
+		return
+	}
+	err = app.models.ExamSessions.Update(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRoomConflict):
+			app.roomConflictResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"exam_session": session, "warnings": conflicts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteExamSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.ExamSessions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "exam session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listExamConflictsHandler reports every pair of overlapping exam sessions between the
+// from and to query parameters which share a room, a proctor or enrolled students.
+func (app *application) listExamConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	now := time.Now()
+	from := app.readTime(qs, "from", now, v)
+	to := app.readTime(qs, "to", now.AddDate(0, 3, 0), v)
+	v.Check(to.After(from), "to", "must be after from")
+	v.Check(to.Sub(from) <= 366*24*time.Hour, "to", "must be no more than a year after from")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	conflicts, err := app.models.ExamSessions.Conflicts(from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"conflicts": conflicts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/validator"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+)
+
+// exportMediaTypeFormats maps the media types an export can be requested with to its
+// format. Wildcards fall back to NDJSON, which is the default.
+var exportMediaTypeFormats = map[string]string{
+	"*/*":                  exportFormatNDJSON,
+	"application/*":        exportFormatNDJSON,
+	"application/x-ndjson": exportFormatNDJSON,
+	"application/ndjson":   exportFormatNDJSON,
+	"text/*":               exportFormatCSV,
+	"text/csv":             exportFormatCSV,
+}
+
+// exportFlushEvery is the number of records written between flushes of the response, so
+// that the client starts receiving the export straight away.
+const exportFlushEvery = 100
+
+// The readExportFormat() helper works out whether an export should be CSV or NDJSON.
+// The format query string parameter takes precedence over the Accept header, whose
+// quality values are honoured in the same way as for every other response, and NDJSON
+// is used when neither asks for anything in particular.
+func (app *application) readExportFormat(r *http.Request, v *validator.Validator) string {
+	format := app.readString(r.URL.Query(), "format", "")
+	if format != "" {
+		v.Check(validator.PermittedValue(format, exportFormatCSV, exportFormatNDJSON), "format", "must be csv or ndjson")
+		return format
+	}
+	format, ok := negotiateMediaType(r.Header.Get("Accept"), exportMediaTypeFormats)
+	if !ok {
+		return exportFormatNDJSON
+	}
+	return format
+}
+
+// csvFormulaPrefixes are the characters which make spreadsheet applications treat a
+// cell as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafe neutralises a CSV field which a spreadsheet application would otherwise run as
+// a formula, by prefixing it with a single quote, so that a value such as a user's name
+// can't be used to attack whoever opens the export.
+func csvSafe(field string) string {
+	if field != "" && strings.ContainsRune(csvFormulaPrefixes, rune(field[0])) {
+		return "'" + field
+	}
+	return field
+}
+
+// exportStream writes records to the response one at a time as they are read from the
+// database, so that memory use doesn't grow with the size of the export. The response
+// headers are only sent with the first record, which leaves the handler free to send an
+// error response instead if the export fails before anything has been written.
+type exportStream struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	format   string
+	filename string
+	columns  []string
+	csv      *csv.Writer
+	json     *json.Encoder
+	started  bool
+	records  int
+}
+
+func newExportStream(w http.ResponseWriter, format, filename string, columns []string) *exportStream {
+	return &exportStream{
+		w:        w,
+		rc:       http.NewResponseController(w),
+		format:   format,
+		filename: filename,
+		columns:  columns,
+	}
+}
+
+func (s *exportStream) start() error {
+	s.started = true
+	// An export can easily take longer than the server's write timeout, so lift it for
+	// this response.
+	s.rc.SetWriteDeadline(time.Time{})
+	switch s.format {
+	case exportFormatCSV:
+		s.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		s.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, s.filename))
+		s.w.WriteHeader(http.StatusOK)
+		s.csv = csv.NewWriter(s.w)
+		return s.csv.Write(s.columns)
+	default:
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+		s.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, s.filename))
+		s.w.WriteHeader(http.StatusOK)
+		s.json = json.NewEncoder(s.w)
+		return nil
+	}
+}
+
+// write sends a single record, as the given CSV fields or as value encoded as a line of
+// JSON, depending on the format of the export.
+func (s *exportStream) write(fields []string, value any) error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch s.format {
+	case exportFormatCSV:
+		safe := make([]string, len(fields))
+		for i, field := range fields {
+			safe[i] = csvSafe(field)
+		}
+		err = s.csv.Write(safe)
+	default:
+		err = s.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+	s.records++
+	if s.records%exportFlushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *exportStream) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return s.rc.Flush()
+}
+
+// finish completes the export, which for an empty one means sending the headers and
+// nothing else but the CSV header row.
+func (s *exportStream) finish() error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+// export runs the given export function and reports any error. Once the response has
+// started there's no way to tell the client that something went wrong, other than
+// leaving the export cut short, so the error is only logged then.
+func (app *application) export(w http.ResponseWriter, r *http.Request, stream *exportStream, fn func() error) {
+	err := fn()
+	if err == nil {
+		err = stream.finish()
+	}
+	if err != nil {
+		if !stream.started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+	}
+}
+
+func (app *application) exportModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format := app.readExportFormat(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	columns := []string{"id", "created_at", "updated_at", "module_name", "module_duration", "exam_type", "capacity", "version"}
+	stream := newExportStream(w, format, "modules", columns)
+	app.export(w, r, stream, func() error {
+		return app.models.InfoModel.Export(r.Context(), func(module *data.ModuleInfo) error {
+			fields := []string{
+				strconv.FormatInt(module.ID, 10),
+				module.CreatedAt.Format(time.RFC3339),
+				module.UpdatedAt.Format(time.RFC3339),
+				module.ModuleName,
+				strconv.Itoa(module.ModuleDuration),
+				module.ExamType,
+				strconv.Itoa(module.Capacity),
+				strconv.Itoa(int(module.Version)),
+			}
+			return stream.write(fields, module)
+		})
+	})
+}
+
+// userExport is the representation of a user in an export. It's spelled out rather than
+// reusing data.User so that nothing sensitive can be added to exports by accident.
+type userExport struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Fname     string    `json:"fname"`
+	Sname     string    `json:"sname"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Activated bool      `json:"activated"`
+	Version   int       `json:"version"`
+}
+
+func (app *application) exportUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format := app.readExportFormat(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	columns := []string{"id", "created_at", "updated_at", "fname", "sname", "email", "role", "activated", "version"}
+	stream := newExportStream(w, format, "users", columns)
+	app.export(w, r, stream, func() error {
+		return app.models.Users.Export(r.Context(), func(user *data.User) error {
+			record := userExport{
+				ID:        user.ID,
+				CreatedAt: user.CreatedAt,
+				UpdatedAt: user.UpdatedAt,
+				Fname:     user.Fname,
+				Sname:     user.Sname,
+				Email:     user.Email,
+				Role:      user.Role,
+				Activated: user.Activated,
+				Version:   user.Version,
+			}
+			fields := []string{
+				strconv.FormatInt(record.ID, 10),
+				record.CreatedAt.Format(time.RFC3339),
+				record.UpdatedAt.Format(time.RFC3339),
+				record.Fname,
+				record.Sname,
+				record.Email,
+				record.Role,
+				strconv.FormatBool(record.Activated),
+				strconv.Itoa(record.Version),
+			}
+			return stream.write(fields, record)
+		})
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"ass2/internal/validator"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -36,7 +37,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Users.Insert(user)
+	// Every new user can read modules. Anything more has to be granted separately.
+	err = app.models.Users.Insert(user, "info:read")
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -47,7 +49,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 20*time.Second, data.ScopeActivation)
+	token, err := app.models.Tokens.New(user.ID, app.config.activation.tokenTTL, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -62,7 +64,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			app.logger.PrintError(err, nil)
 		}
 	})
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -109,7 +111,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -133,34 +135,57 @@ func (app *application) getUserInfoHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user_info": userInfo}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user_info": userInfo}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) getAllUserInfoHandler(w http.ResponseWriter, r *http.Request) {
-	userInfos, err := app.models.Users.GetAll()
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "fname", "sname", "email", "created_at", "-id", "-fname", "-sname", "-email", "-created_at"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var (
+		userInfos []*data.User
+		metadata  data.Metadata
+		err       error
+	)
+	if input.Filters.Cursor != "" {
+		userInfos, metadata, err = app.models.Users.GetAllAfterCursor(input.Filters)
+	} else {
+		userInfos, metadata, err = app.models.Users.GetAll(input.Filters)
+	}
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user_infos": userInfos}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user_infos": userInfos, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// editUserInfoHandler changes a user's name. A new email address isn't set straight away
+// but goes through the same confirmation as requestEmailChangeHandler, so that an
+// address is never attached to an account without its owner's knowledge.
 func (app *application) editUserInfoHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	userInfo, err := app.models.Users.Get(id)
@@ -182,21 +207,60 @@ func (app *application) editUserInfoHandler(w http.ResponseWriter, r *http.Reque
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	userInfo.Fname = input.Name
 	userInfo.Sname = input.Surname
-	userInfo.Email = input.Email
+	emailChanged := !strings.EqualFold(input.Email, userInfo.Email)
+
+	v := validator.New()
+	data.ValidateUser(v, userInfo)
+	if emailChanged {
+		data.ValidateEmail(v, input.Email)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if emailChanged {
+		_, err = app.models.Users.GetByEmail(input.Email)
+		switch {
+		case err == nil:
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
 	err = app.models.Users.Update(userInfo)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"userInfo": userInfo}, nil)
+	env := envelope{"userInfo": userInfo}
+	if emailChanged {
+		err = app.startEmailChange(userInfo, input.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["message"] = "an email will be sent to the new address containing instructions to confirm it"
+	}
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -219,40 +283,345 @@ func (app *application) deleteUserInfoHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user info successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "user info successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+func (app *application) listDeletedUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "email", "deleted_at", "-id", "-email", "-deleted_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userInfos, metadata, err := app.models.Users.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user_infos": userInfos, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreUserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userInfo, err := app.models.Users.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.emailTakenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user_info": userInfo}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
-func (app *application) checkAndResendActivation() {
-	ticker := time.NewTicker(10 * time.Second)
-	for range ticker.C {
-		users, err := app.models.Users.FindNotActivatedAndExpired()
+
+// updateUserPasswordHandler sets a new password for the user a password reset token was
+// issued to. All of the user's sessions are revoked, since the reason for the reset may
+// be that someone else has got hold of the old password.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, scope := range append([]string{data.ScopePasswordReset}, sessionScopes...) {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
 		if err != nil {
-			app.logger.PrintFatal(err, nil)
+			app.serverErrorResponse(w, r, err)
 			return
 		}
-		for _, user := range users {
-			err := app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
-			}
-			token, err := app.models.Tokens.New(user.ID, time.Second*20, data.ScopeActivation)
-			if err != nil {
-				app.logger.PrintError(err, nil)
-				return
-			}
-			app.background(func() {
-				data := map[string]any{
-					"activationToken": token.Plaintext,
-					"userID":          user.ID,
-				}
-				err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-				if err != nil {
-					app.logger.PrintError(err, nil)
-				}
-			})
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changePasswordHandler lets a signed in user change their password, provided that they
+// know the current one. A wrong current password counts as a failed login.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.NewPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	if app.loginLimitsReached(w, r, user) {
+		return
+	}
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		err = app.recordFailedLogin(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
 		}
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// An outstanding password reset token would let someone undo the change, and any
+	// other session could be someone who got hold of the old password. The session
+	// making the change stays signed in.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	currentHash := data.HashTokenPlaintext(app.readBearerToken(r))
+	for _, scope := range sessionScopes {
+		err = app.models.Tokens.DeleteAllForUserExcept(scope, user.ID, currentHash)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startEmailChange records email as the user's pending email address, then sends a
+// confirmation token to it and a notice to the user's current address. Only the latest
+// pending address can be confirmed.
+func (app *application) startEmailChange(user *data.User, email string) error {
+	err := app.models.Users.SetPendingEmail(user.ID, email)
+	if err != nil {
+		return err
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		return err
+	}
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		return err
+	}
+	currentEmail := user.Email
+	app.background(func() {
+		err := app.mailer.Send(email, "email_change_confirm.tmpl", map[string]any{
+			"emailChangeToken": token.Plaintext,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		err = app.mailer.Send(currentEmail, "email_change_notice.tmpl", map[string]any{
+			"newEmail": email,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	return nil
+}
+
+// requestEmailChangeHandler starts changing the user's email address. A confirmation
+// token is sent to the new address, and a notice to the current one, but the address
+// only changes once the token is sent back to PUT /v1/users/email. The current password
+// is required as well, since whoever controls the email address can reset the password,
+// and as with changePasswordHandler a wrong one counts as a failed login.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from your current email address")
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if app.loginLimitsReached(w, r, user) {
+		return
+	}
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		err = app.recordFailedLogin(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.startEmailChange(user, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"message": "an email will be sent to the new address containing instructions to confirm it"}
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler swaps the user's email address for the pending one which an
+// email change token was sent to.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Users.ConfirmPendingEmail(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -1,39 +1,51 @@
 package main
 
 import (
+	"ass2/internal/validator"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]any
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper reads an id from a route parameter other than :id, for
+// routes which identify more than one record.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	js, err := json.MarshalIndent(data, "", "\t")
+// The writeResponse() helper encodes the envelope in the format negotiated from the
+// request's Accept header (JSON unless the client asked for XML or YAML) and sends it
+// with the given status code and headers.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	format := app.contextGetFormat(r)
+	body, err := encode(format, data)
 	if err != nil {
 		return err
 	}
-	js = append(js, '\n')
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", formatContentTypes[format])
 	w.WriteHeader(status)
-	w.Write(js)
+	w.Write(body)
 	return nil
 }
 
@@ -76,7 +88,127 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		return errors.New("body must only contain a single JSON value")
 	}
 	return nil
-	return nil
+}
+
+// optional is a member of a JSON merge patch (RFC 7396). Set reports whether the member
+// was in the request body at all, and Null whether it was given as null.
+type optional[T any] struct {
+	Value T
+	Set   bool
+	Null  bool
+}
+
+func (o *optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if string(b) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
+
+// The etag() helper builds a strong entity tag for a versioned record. The version
+// number is bumped on every update, so the pair changes whenever the record does.
+func (app *application) etag(id int64, version int32) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// The ifMatch() helper reports whether the request's If-Match precondition (if any) is
+// satisfied by the given entity tag. Requests without the header always match, so that
+// clients which don't use conditional requests keep working. If-Match uses the strong
+// comparison, so weak tags (W/"...") never match.
+func (app *application) ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The readString() helper returns a string value from the query string, or the provided
+// default value if no matching key could be found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// The readCSV() helper reads a string value from the query string and then splits it
+// into a slice on the comma character. If no matching key could be found, it returns
+// the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+	return strings.Split(csv, ",")
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// The readBool() helper reads a boolean value from the query string. If no matching key
+// could be found it returns the provided default value, and if the value couldn't be
+// parsed it records an error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string, in the same
+// way as readBool().
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return defaultValue
+	}
+	return t
+}
+
+// The readBearerToken() helper returns the token from the request's "Authorization:
+// Bearer <token>" header, or the empty string if there isn't one. By the time a handler
+// runs the authenticate() middleware has already checked the token.
+func (app *application) readBearerToken(r *http.Request) string {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return ""
+	}
+	return headerParts[1]
 }
 
 func (app *application) background(fn func()) {
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/validator"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// moduleImportColumns is the header row expected at the top of a module import.
+var moduleImportColumns = []string{"module_name", "module_duration", "exam_type"}
+
+const (
+	importRowCreated = "created"
+	importRowValid   = "valid"
+	importRowInvalid = "invalid"
+)
+
+// importRow is the outcome of importing a single CSV row. Line is the line of the file
+// the row starts on, counting the header as line 1.
+type importRow struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	Module *data.ModuleInfo  `json:"module,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importModuleInfoHandler creates modules from a CSV file, reporting on every row. Rows
+// which fail validation are left out and the rest are inserted in a single transaction,
+// unless ?atomic=true is set, in which case a single invalid row means nothing is
+// inserted. With ?dry_run=true everything is checked but nothing is saved.
+func (app *application) importModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	dryRun := app.readBool(qs, "dry_run", false, v)
+	atomic := app.readBool(qs, "atomic", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	maxBytes := 10_485_760
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			app.badRequestResponse(w, r, errors.New("body must not be empty"))
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed CSV: %w", err))
+		}
+		return
+	}
+	if !csvHeaderMatches(header, moduleImportColumns) {
+		app.badRequestResponse(w, r, fmt.Errorf("header row must be %s", strings.Join(moduleImportColumns, ",")))
+		return
+	}
+
+	rows := []*importRow{}
+	modules := []*data.ModuleInfo{}
+	invalid := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+			case errors.As(err, &parseError):
+				app.badRequestResponse(w, r, fmt.Errorf("body contains badly-formed CSV: %w", err))
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		row := &importRow{Line: line}
+		rows = append(rows, row)
+
+		module, rowValidator := parseModuleImportRecord(record)
+		if !rowValidator.Valid() {
+			row.Status = importRowInvalid
+			row.Errors = rowValidator.Errors
+			invalid++
+			continue
+		}
+		row.Status = importRowValid
+		row.Module = module
+		modules = append(modules, module)
+	}
+
+	// An atomic import doesn't touch the database at all once a row has failed, but the
+	// valid rows are still reported as such.
+	if atomic && invalid > 0 {
+		for _, row := range rows {
+			row.Module = nil
+		}
+		env := envelope{"rows": rows, "summary": importSummary(rows)}
+		err = app.writeResponse(w, r, http.StatusUnprocessableEntity, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(modules) > 0 {
+		err = app.models.InfoModel.InsertMany(modules, app.contextGetUser(r).ID, dryRun)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	status := http.StatusOK
+	for _, row := range rows {
+		switch {
+		case row.Status != importRowValid:
+		case dryRun:
+			// The modules were rolled back, so their ids and timestamps don't mean
+			// anything.
+			row.Module = nil
+		default:
+			row.Status = importRowCreated
+			status = http.StatusCreated
+		}
+	}
+	err = app.writeResponse(w, r, status, envelope{"dry_run": dryRun, "rows": rows, "summary": importSummary(rows)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseModuleImportRecord turns a CSV record into a module, checking it in the same way
+// as a module created through the JSON API.
+func parseModuleImportRecord(record []string) (*data.ModuleInfo, *validator.Validator) {
+	v := validator.New()
+	if len(record) != len(moduleImportColumns) {
+		v.AddError("row", fmt.Sprintf("must have %d fields", len(moduleImportColumns)))
+		return nil, v
+	}
+	module := &data.ModuleInfo{
+		ModuleName: strings.TrimSpace(record[0]),
+		ExamType:   strings.TrimSpace(record[2]),
+		Capacity:   data.DefaultModuleCapacity,
+	}
+	duration, err := strconv.Atoi(strings.TrimSpace(record[1]))
+	if err != nil {
+		v.AddError("module_duration", "must be an integer value")
+	}
+	module.ModuleDuration = duration
+	data.ValidateModuleInfo(v, module)
+	return module, v
+}
+
+func csvHeaderMatches(header, columns []string) bool {
+	if len(header) != len(columns) {
+		return false
+	}
+	for i := range header {
+		name := strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+		if !strings.EqualFold(name, columns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func importSummary(rows []*importRow) map[string]int {
+	summary := map[string]int{"total": len(rows)}
+	for _, row := range rows {
+		summary[row.Status]++
+	}
+	return summary
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseModuleImportRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		record []string
+		errors []string
+	}{
+		{"valid", []string{"Databases", "10", "written"}, nil},
+		{"shortest duration", []string{"Databases", "6", "written"}, nil},
+		{"longest duration", []string{"Databases", "15", "written"}, nil},
+		{"surrounding spaces", []string{" Databases ", " 10 ", " written "}, nil},
+		{"duration too short", []string{"Databases", "5", "written"}, []string{"module_duration"}},
+		{"duration too long", []string{"Databases", "16", "written"}, []string{"module_duration"}},
+		{"negative duration", []string{"Databases", "-3", "written"}, []string{"module_duration"}},
+		{"duration not a number", []string{"Databases", "ten", "written"}, []string{"module_duration"}},
+		{"name too long", []string{strings.Repeat("a", 256), "10", "written"}, []string{"module_name"}},
+		{"exam type too long", []string{"Databases", "10", strings.Repeat("a", 256)}, []string{"exam_type"}},
+		{"missing fields", []string{"", "10", ""}, []string{"module_name", "exam_type"}},
+		{"wrong field count", []string{"Databases", "10"}, []string{"row"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, v := parseModuleImportRecord(tt.record)
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("got errors %v, want errors for %v", v.Errors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("got errors %v, want an error for %q", v.Errors, key)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"ass2/internal/validator"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 )
 
@@ -13,6 +14,7 @@ func (app *application) createModuleInfoHandler(w http.ResponseWriter, r *http.R
 		ModuleName     string `json:"module_name"`
 		ModuleDuration int    `json:"module_duration"`
 		ExamType       string `json:"exam_type"`
+		Capacity       *int   `json:"capacity"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -23,20 +25,25 @@ func (app *application) createModuleInfoHandler(w http.ResponseWriter, r *http.R
 		ModuleName:     input.ModuleName,
 		ModuleDuration: input.ModuleDuration,
 		ExamType:       input.ExamType,
+		Capacity:       data.DefaultModuleCapacity,
+	}
+	if input.Capacity != nil {
+		module.Capacity = *input.Capacity
 	}
 	v := validator.New()
 	if data.ValidateModuleInfo(v, module); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.InfoModel.Insert(module)
+	err = app.models.InfoModel.Insert(module, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/module_infos/%d", module.ID))
-	err = app.writeJSON(w, http.StatusCreated, envelope{"module_info": module}, headers)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"module_info": module}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -58,7 +65,9 @@ func (app *application) showModuleInfoHandler(w http.ResponseWriter, r *http.Req
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": module}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -80,10 +89,20 @@ func (app *application) updateModuleInfoHandler(w http.ResponseWriter, r *http.R
 		}
 		return
 	}
+	// If the client sent an If-Match header, make sure that it still refers to the
+	// version of the record we just fetched. Otherwise someone else has updated it in
+	// the meantime and the client would silently overwrite their changes.
+	if !app.ifMatch(r, app.etag(module.ID, module.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	// Capacity was added after this endpoint, so it is optional here for the sake of
+	// existing clients and left unchanged when it isn't given.
 	var input struct {
 		ModuleName     string `json:"module_name"`
 		ModuleDuration int    `json:"module_duration"`
 		ExamType       string `json:"exam_type"`
+		Capacity       *int   `json:"capacity"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -93,17 +112,254 @@ func (app *application) updateModuleInfoHandler(w http.ResponseWriter, r *http.R
 	module.ModuleName = input.ModuleName
 	module.ModuleDuration = input.ModuleDuration
 	module.ExamType = input.ExamType
+	if input.Capacity != nil {
+		module.Capacity = *input.Capacity
+	}
 	v := validator.New()
 	if data.ValidateModuleInfo(v, module); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.InfoModel.Update(module)
+	promoted, err := app.models.InfoModel.Update(module, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, enrollment := range promoted {
+		app.notifyPromotion(enrollment)
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) patchModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	module, err := app.models.InfoModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.ifMatch(r, app.etag(module.ID, module.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json-patch+json":
+		var ops []data.PatchOperation
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		err = module.ApplyJSONPatch(ops)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrPatchTestFailed):
+				app.patchTestFailedResponse(w, r)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+	case "application/merge-patch+json":
+		// Use optional fields so that we can tell the difference between a member that
+		// was left out of the request body, which is left as it is, and one that was
+		// set to null. In a merge patch null removes the member, which none of these
+		// can be, so it is rejected rather than ignored.
+		var input struct {
+			ModuleName     optional[string] `json:"module_name"`
+			ModuleDuration optional[int]    `json:"module_duration"`
+			ExamType       optional[string] `json:"exam_type"`
+			Capacity       optional[int]    `json:"capacity"`
+		}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		v := validator.New()
+		v.Check(!input.ModuleName.Null, "module_name", "must not be null")
+		v.Check(!input.ModuleDuration.Null, "module_duration", "must not be null")
+		v.Check(!input.ExamType.Null, "exam_type", "must not be null")
+		v.Check(!input.Capacity.Null, "capacity", "must not be null")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		if input.ModuleName.Set {
+			module.ModuleName = input.ModuleName.Value
+		}
+		if input.ModuleDuration.Set {
+			module.ModuleDuration = input.ModuleDuration.Value
+		}
+		if input.ExamType.Set {
+			module.ExamType = input.ExamType.Value
+		}
+		if input.Capacity.Set {
+			module.Capacity = input.Capacity.Value
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateModuleInfo(v, module); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	promoted, err := app.models.InfoModel.Update(module, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, enrollment := range promoted {
+		app.notifyPromotion(enrollment)
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) historyModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	revisions, err := app.models.InfoModel.History(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The history outlives the module, so only a module with no history at all has to
+	// exist for the empty history to be returned rather than a 404.
+	if len(revisions) == 0 {
+		_, err = app.models.InfoModel.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"history": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertModuleInfoHandler puts a module back into the state it was in after the chosen
+// revision. The old values are saved as a normal update would be, so they are validated
+// again, the version is bumped and the revert shows up in the history itself.
+func (app *application) revertModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		RevisionID int64 `json:"revision_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.RevisionID > 0, "revision_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	module, err := app.models.InfoModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.ifMatch(r, app.etag(module.ID, module.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	revision, err := app.models.InfoModel.GetRevision(id, input.RevisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("revision_id", "no such revision for this module")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if revision.After == nil {
+		v.AddError("revision_id", "cannot revert to a deletion")
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": module}, nil)
+	module.ModuleName = revision.After.ModuleName
+	module.ModuleDuration = revision.After.ModuleDuration
+	module.ExamType = revision.After.ExamType
+	// Revisions recorded before modules had a capacity don't hold one, in which case the
+	// current capacity is kept.
+	if revision.After.Capacity > 0 {
+		module.Capacity = revision.After.Capacity
+	}
+	if data.ValidateModuleInfo(v, module); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	promoted, err := app.models.InfoModel.Revert(module, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, enrollment := range promoted {
+		app.notifyPromotion(enrollment)
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -115,7 +371,7 @@ func (app *application) deleteModuleInfoHandler(w http.ResponseWriter, r *http.R
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.InfoModel.Delete(id)
+	err = app.models.InfoModel.Delete(id, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -125,19 +381,126 @@ func (app *application) deleteModuleInfoHandler(w http.ResponseWriter, r *http.R
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "module_info successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "module_info successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listDeletedModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "module_name", "deleted_at", "-id", "-module_name", "-deleted_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	modules, metadata, err := app.models.InfoModel.GetAllDeleted(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": modules, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	module, err := app.models.InfoModel.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRoomConflict):
+			app.roomConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(module.ID, module.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": module}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) getAllModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
-	modules, err := app.models.InfoModel.GetAll()
+	var input struct {
+		ModuleName string
+		ExamType   string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.ModuleName = app.readString(qs, "module_name", "")
+	input.ExamType = app.readString(qs, "exam_type", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "module_name", "module_duration", "created_at", "-id", "-module_name", "-module_duration", "-created_at"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var (
+		modules  []*data.ModuleInfo
+		metadata data.Metadata
+		err      error
+	)
+	if input.Filters.Cursor != "" {
+		modules, metadata, err = app.models.InfoModel.GetAllAfterCursor(input.ModuleName, input.ExamType, input.Filters)
+	} else {
+		modules, metadata, err = app.models.InfoModel.GetAll(input.ModuleName, input.ExamType, input.Filters)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": modules, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) searchModuleInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Query = app.readString(qs, "q", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-rank")
+	input.Filters.SortSafelist = []string{"-rank", "id", "module_name", "module_duration", "created_at", "-id", "-module_name", "-module_duration", "-created_at"}
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 500, "q", "must not be more than 500 bytes long")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	results, metadata, err := app.models.InfoModel.Search(input.Query, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"module_info": modules}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"module_info": results, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// loginFailures counts failed logins per IP address over a sliding window, so that one
+// client can't try passwords against many accounts without tripping their lockouts.
+type loginFailures struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	failures  map[string]*ipFailures
+	lastSweep time.Time
+}
+
+type ipFailures struct {
+	count int
+	first time.Time
+}
+
+func newLoginFailures(limit int, window time.Duration) *loginFailures {
+	return &loginFailures{
+		limit:     limit,
+		window:    window,
+		failures:  make(map[string]*ipFailures),
+		lastSweep: time.Now(),
+	}
+}
+
+// blocked reports whether the IP address has used up its failed logins for the current
+// window, and if so how long is left of it.
+func (l *loginFailures) blocked(ip string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[ip]
+	if !ok || now.Sub(f.first) >= l.window || f.count < l.limit {
+		return false, 0
+	}
+	return true, l.window - now.Sub(f.first)
+}
+
+func (l *loginFailures) fail(ip string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the addresses whose window has run out every so often, so that the map
+	// doesn't keep growing.
+	if now.Sub(l.lastSweep) > l.window {
+		for k, f := range l.failures {
+			if now.Sub(f.first) >= l.window {
+				delete(l.failures, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	f, ok := l.failures[ip]
+	if !ok || now.Sub(f.first) >= l.window {
+		l.failures[ip] = &ipFailures{count: 1, first: now}
+		return
+	}
+	f.count++
+}
+
+func (l *loginFailures) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+}
+
+func (app *application) lockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		Threshold:    app.config.lockout.threshold,
+		BaseDuration: app.config.lockout.baseDuration,
+		MaxDuration:  app.config.lockout.maxDuration,
+	}
+}
+
+// recordFailedLogin counts a failed login against the client's IP address and, if the
+// account is known, against the account too. When that locks the account the user is
+// sent an email about it.
+func (app *application) recordFailedLogin(r *http.Request, user *data.User) error {
+	ip := app.tokenClient(r).IPAddress
+	app.loginFailures.fail(ip)
+	if user == nil {
+		return nil
+	}
+	lockout, err := app.models.Lockouts.RecordFailure(user.ID, ip, app.lockoutPolicy())
+	if err != nil || lockout == nil {
+		return err
+	}
+	app.logger.PrintInfo("account locked", map[string]string{
+		"user_id":      strconv.FormatInt(user.ID, 10),
+		"ip_address":   ip,
+		"locked_until": lockout.LockedUntil.Format(time.RFC3339),
+	})
+	app.background(func() {
+		data := map[string]any{
+			"lockedUntil":    lockout.LockedUntil.UTC().Format(time.RFC1123),
+			"failedAttempts": lockout.FailedAttempts,
+			"ipAddress":      lockout.IPAddress,
+		}
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	return nil
+}
+
+// loginLimitsReached checks the limits on failed logins for a signed in user before
+// they are asked for their password or a two-factor code again, and if either the
+// client's IP address or the account has reached its limit it sends the response. A
+// stolen session could otherwise be used to guess them.
+func (app *application) loginLimitsReached(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	if blocked, retryAfter := app.loginFailures.blocked(app.tokenClient(r).IPAddress); blocked {
+		app.cooldownResponse(w, r, retryAfter)
+		return true
+	}
+	lockedUntil, err := app.models.Lockouts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, *lockedUntil)
+		return true
+	}
+	return false
+}
+
+// recordSuccessfulLogin clears the failed logins counted against the account and the
+// client's IP address.
+func (app *application) recordSuccessfulLogin(r *http.Request, userID int64) error {
+	app.loginFailures.reset(app.tokenClient(r).IPAddress)
+	return app.models.Lockouts.Reset(userID)
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Lockouts.Unlock(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	lockouts, err := app.models.Lockouts.GetAllForUser(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"lockouts": lockouts}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
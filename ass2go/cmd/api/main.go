@@ -31,6 +31,31 @@ type config struct {
 		rps     float64
 		burst   int
 	}
+	trash struct {
+		retention time.Duration
+	}
+	programs struct {
+		semesterLoadLimit int
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	activation struct {
+		tokenTTL time.Duration
+		cooldown time.Duration
+		maxAge   time.Duration
+	}
+	passwordReset struct {
+		cooldown time.Duration
+	}
+	lockout struct {
+		threshold    int
+		baseDuration time.Duration
+		maxDuration  time.Duration
+		ipLimit      int
+		ipWindow     time.Duration
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -46,6 +71,12 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+
+	tokenUsage            *tokenUsage
+	apiKeyUsage           *tokenUsage
+	activationCooldown    *cooldown
+	passwordResetCooldown *cooldown
+	loginFailures         *loginFailures
 }
 
 func main() {
@@ -58,6 +89,25 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted records are kept before being purged")
+
+	flag.IntVar(&cfg.programs.semesterLoadLimit, "semester-load-limit", 30, "Maximum total module duration of a program semester")
+
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "Lifetime of authentication tokens")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	flag.DurationVar(&cfg.activation.tokenTTL, "activation-token-ttl", 3*24*time.Hour, "Lifetime of activation tokens")
+	flag.DurationVar(&cfg.activation.cooldown, "activation-cooldown", 5*time.Minute, "Minimum time between activation emails to the same address")
+	flag.DurationVar(&cfg.activation.maxAge, "activation-max-age", 14*24*time.Hour, "How long an account can stay unactivated before it is deleted")
+
+	flag.DurationVar(&cfg.passwordReset.cooldown, "password-reset-cooldown", 5*time.Minute, "Minimum time between password reset emails to the same address")
+
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", 5, "Failed logins after which an account is locked")
+	flag.DurationVar(&cfg.lockout.baseDuration, "lockout-duration", 5*time.Minute, "Duration of the first lock of an account, doubled for each further lock")
+	flag.DurationVar(&cfg.lockout.maxDuration, "lockout-max-duration", 24*time.Hour, "Maximum duration of an account lock")
+	flag.IntVar(&cfg.lockout.ipLimit, "login-ip-limit", 20, "Failed logins allowed from one IP address per window")
+	flag.DurationVar(&cfg.lockout.ipWindow, "login-ip-window", 15*time.Minute, "Window over which failed logins from one IP address are counted")
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "bfd7f132b999b4", "SMTP username")
@@ -79,8 +129,17 @@ func main() {
 		logger: logger,
 		models: data.NewModels(db),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+
+		tokenUsage:            newTokenUsage(),
+		apiKeyUsage:           newTokenUsage(),
+		activationCooldown:    newCooldown(cfg.activation.cooldown),
+		passwordResetCooldown: newCooldown(cfg.passwordReset.cooldown),
+		loginFailures:         newLoginFailures(cfg.lockout.ipLimit, cfg.lockout.ipWindow),
 	}
-	go app.checkAndResendActivation()
+	go app.purgeDeletedRecords()
+	go app.deleteUnactivatedUsers()
+	go app.deleteExpiredTokens()
+	go app.flushTokenUsage()
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
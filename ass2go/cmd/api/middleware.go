@@ -16,6 +16,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+		// Services authenticate with an API key instead, sent either in the X-API-Key
+		// header or as "Authorization: ApiKey <key>".
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			app.authenticateAPIKey(w, r, next, key)
+			return
+		}
 		// Retrieve the value of the Authorization header from the request. This will
 		// return the empty string "" if there is no such header found.
 		authorizationHeader := r.Header.Get("Authorization")
@@ -34,6 +41,10 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// using the invalidAuthenticationTokenResponse() helper (which we will create
 		// in a moment).
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -63,6 +74,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Note that the token has been used. This is only written to the database
+		// periodically, by flushTokenUsage().
+		app.tokenUsage.record(data.HashTokenPlaintext(token))
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
@@ -114,6 +128,42 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey adds the owner of the API key to the request context, along with
+// the key itself so that requirePermission() can hold the request to the key's
+// permissions.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+	key, err := app.models.APIKeys.GetForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The key stops working along with its owner's account, if that is deleted.
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.apiKeyUsage.record(key.Hash)
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -136,6 +186,12 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
+		// API keys are only good for the routes guarded by requirePermission(), which
+		// doesn't go through this middleware.
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotPermittedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -156,22 +212,80 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			app.notPermittedResponse(w, r)
 			return
 		}
+		// Requests made with an API key are also limited to the permissions the key
+		// was created with.
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 		// Otherwise they have the required permission so we call the next handler in
 		// the chain.
 		next.ServeHTTP(w, r)
 	}
-	// Wrap this with the requireActivatedUser() middleware before returning it.
-	return app.requireActivatedUser(fn)
+	// Check that the user is authenticated and activated first. This is done here
+	// rather than with requireActivatedUser(), because unlike the other routes, those
+	// guarded by a permission can be used with an API key.
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+		fn(w, r)
+	}
 }
 
-func (app *application) requireAdminRole(next http.HandlerFunc) http.HandlerFunc {
+// authenticateCalendar lets calendar feeds be fetched with a calendar token in the token
+// query string parameter, since calendar apps can't send an Authorization header. The
+// token only ever grants access to the routes wrapped by this middleware.
+func (app *application) authenticateCalendar(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userInfo := app.contextGetUser(r)
-		println(userInfo.Role)
-		if userInfo.Role != "admin" {
-			app.notPermittedResponse(w, r)
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			next(w, r)
+			return
+		}
+		v := validator.New()
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		user, err := app.models.Users.GetForToken(data.ScopeCalendar, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
+		r = app.contextSetUser(r, user)
 		next(w, r)
 	}
 }
+
+// negotiateContent picks the encoding for the response from the Accept header and adds
+// it to the request context for writeResponse() to use. Requests which don't accept any
+// of the encodings we support are rejected up front, before any handler has run, except
+// on the routes in ownMediaType. Those don't respond through writeResponse() but pick
+// their own media types, so they are let through with JSON for any errors.
+func (app *application) negotiateContent(next http.Handler, ownMediaType routeSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		format, ok := negotiateFormat(r.Header.Get("Accept"))
+		if !ok {
+			format = formatJSON
+			if !ownMediaType.contains(r.Method, r.URL.Path) {
+				app.notAcceptableResponse(w, r)
+				return
+			}
+		}
+		r = app.contextSetFormat(r, format)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/validator"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) showPrerequisitesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	tree, err := app.models.InfoModel.PrerequisiteTree(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"prerequisites": tree}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addPrerequisiteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		PrerequisiteID int64 `json:"prerequisite_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.PrerequisiteID > 0, "prerequisite_id", "must be provided")
+	v.Check(input.PrerequisiteID != id, "prerequisite_id", "a module cannot be its own prerequisite")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.models.InfoModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	_, err = app.models.InfoModel.Get(input.PrerequisiteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("prerequisite_id", "no such module")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.InfoModel.AddPrerequisite(id, input.PrerequisiteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrPrerequisiteCycle):
+			v.AddError("prerequisite_id", "would create a cycle in the prerequisite graph")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	tree, err := app.models.InfoModel.PrerequisiteTree(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"prerequisites": tree}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removePrerequisiteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	prerequisiteID, err := app.readNamedIDParam(r, "prerequisite_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.InfoModel.RemovePrerequisite(id, prerequisiteID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "prerequisite successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) studyOrderHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	var ids []int64
+	for _, s := range app.readCSV(r.URL.Query(), "ids", nil) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("ids", "must be a comma-separated list of module ids")
+			break
+		}
+		ids = append(ids, id)
+	}
+	v.Check(len(ids) > 0, "ids", "must be provided")
+	v.Check(len(ids) <= 100, "ids", "must not contain more than 100 ids")
+	v.Check(validator.Unique(ids), "ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	modules, err := app.models.InfoModel.StudyOrder(ids)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("ids", "must only contain existing modules")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"study_order": modules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
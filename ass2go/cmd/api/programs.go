@@ -0,0 +1,232 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// programInput is the request body accepted when creating or replacing a program.
+type programInput struct {
+	Name        string `json:"name"`
+	DegreeLevel string `json:"degree_level"`
+	Semesters   []struct {
+		Number    int     `json:"number"`
+		ModuleIDs []int64 `json:"module_ids"`
+	} `json:"semesters"`
+}
+
+func (input programInput) apply(program *data.Program) {
+	program.Name = input.Name
+	program.DegreeLevel = input.DegreeLevel
+	program.Semesters = []*data.Semester{}
+	for _, semester := range input.Semesters {
+		program.Semesters = append(program.Semesters, &data.Semester{
+			Number:    semester.Number,
+			ModuleIDs: semester.ModuleIDs,
+		})
+	}
+}
+
+func (app *application) createProgramHandler(w http.ResponseWriter, r *http.Request) {
+	var input programInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	program := &data.Program{}
+	input.apply(program)
+	v := validator.New()
+	if data.ValidateProgram(v, program); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Programs.Insert(program)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownProgramModule):
+			v.AddError("semesters", "must only contain existing modules")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	program, err = app.models.Programs.Get(program.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/programs/%d", program.ID))
+	headers.Set("ETag", app.etag(program.ID, program.Version))
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"program": program}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showProgramHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	program, err := app.models.Programs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(program.ID, program.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"program": program}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showProgramLoadHandler reports the total module duration of every semester of a
+// program, flagging the semesters which go over the configured load limit.
+func (app *application) showProgramLoadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	program, err := app.models.Programs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	loads := program.Loads(app.config.programs.semesterLoadLimit)
+	overloaded := false
+	for _, load := range loads {
+		overloaded = overloaded || load.Overloaded
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"program_id": program.ID, "semesters": loads, "overloaded": overloaded}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listProgramsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string
+		DegreeLevel string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Name = app.readString(qs, "name", "")
+	input.DegreeLevel = app.readString(qs, "degree_level", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "degree_level", "created_at", "-id", "-name", "-degree_level", "-created_at"}
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	programs, metadata, err := app.models.Programs.GetAll(input.Name, input.DegreeLevel, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"programs": programs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateProgramHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	program, err := app.models.Programs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.ifMatch(r, app.etag(program.ID, program.Version)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	var input programInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	input.apply(program)
+	v := validator.New()
+	if data.ValidateProgram(v, program); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Programs.Update(program)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownProgramModule):
+			v.AddError("semesters", "must only contain existing modules")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	program, err = app.models.Programs.Get(program.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", app.etag(program.ID, program.Version))
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"program": program}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteProgramHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Programs.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "program successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// purgeDeletedRecords periodically removes the modules and users which have been in the
+// trash for longer than the configured retention period. Errors are logged rather than
+// treated as fatal, so a database hiccup only delays the purge until the next tick.
+func (app *application) purgeDeletedRecords() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		modules, err := app.models.InfoModel.Purge(app.config.trash.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		users, err := app.models.Users.Purge(app.config.trash.retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if modules > 0 || users > 0 {
+			app.logger.PrintInfo("purged deleted records", map[string]string{
+				"module_info": strconv.FormatInt(modules, 10),
+				"users":       strconv.FormatInt(users, 10),
+			})
+		}
+	}
+}
+
+// deleteUnactivatedUsers periodically moves the accounts which were never activated to
+// the trash, in the same way as purgeDeletedRecords().
+func (app *application) deleteUnactivatedUsers() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		users, err := app.models.Users.DeleteUnactivated(app.config.activation.maxAge)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if users > 0 {
+			app.logger.PrintInfo("deleted unactivated users", map[string]string{
+				"users": strconv.FormatInt(users, 10),
+			})
+		}
+	}
+}
+
+// deleteExpiredTokens periodically removes the tokens which have expired, in the same
+// way as purgeDeletedRecords(). Every refresh leaves a used refresh token and an old
+// authentication token behind, so without this the tokens table would keep growing.
+func (app *application) deleteExpiredTokens() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		tokens, err := app.models.Tokens.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+		if tokens > 0 {
+			app.logger.PrintInfo("deleted expired tokens", map[string]string{
+				"tokens": strconv.FormatInt(tokens, 10),
+			})
+		}
+	}
+}
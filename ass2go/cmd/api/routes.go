@@ -10,20 +10,113 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodPost, "/v1/info", app.requireAdminRole(app.createModuleInfoHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/info", app.requireActivatedUser(app.getAllModuleInfoHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/info/:id", app.requireActivatedUser(app.showModuleInfoHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/info/:id", app.requireAdminRole(app.updateModuleInfoHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/info/:id", app.requireAdminRole(app.deleteModuleInfoHandler))
+	// Routes which respond with media types of their own, such as the iCalendar feeds
+	// and the CSV and NDJSON exports, are registered with ownMediaType.add() so that
+	// negotiateContent() lets them through whatever the Accept header asks for.
+	ownMediaType := routeSet{}
+
+	router.HandlerFunc(http.MethodPost, "/v1/info", app.requirePermission("info:write", app.createModuleInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/info", app.requirePermission("info:read", app.getAllModuleInfoHandler))
+	ownMediaType.add(http.MethodGet, "/v1/info/export")
+	router.HandlerFunc(http.MethodGet, "/v1/info/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"search":      app.requirePermission("info:read", app.searchModuleInfoHandler),
+		"trash":       app.requirePermission("info:write", app.listDeletedModuleInfoHandler),
+		"study-order": app.requirePermission("info:read", app.studyOrderHandler),
+		"export":      app.requirePermission("info:read", app.exportModuleInfoHandler),
+	}, app.requirePermission("info:read", app.showModuleInfoHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/info/:id", app.requirePermission("info:write", app.updateModuleInfoHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/info/:id", app.requirePermission("info:write", app.patchModuleInfoHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/info/:id", app.requirePermission("info:write", app.deleteModuleInfoHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/info/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"import": app.requirePermission("info:write", app.importModuleInfoHandler),
+	}, nil))
+	router.HandlerFunc(http.MethodPost, "/v1/info/:id/restore", app.requirePermission("info:write", app.restoreModuleInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/info/:id/history", app.requirePermission("info:write", app.historyModuleInfoHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/info/:id/revert", app.requirePermission("info:write", app.revertModuleInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/info/:id/prerequisites", app.requirePermission("info:read", app.showPrerequisitesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/info/:id/prerequisites", app.requirePermission("info:write", app.addPrerequisiteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/info/:id/prerequisites/:prerequisite_id", app.requirePermission("info:write", app.removePrerequisiteHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/info/:id/enrollments", app.requirePermission("users:read", app.listEnrollmentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/info/:id/enrollments", app.requirePermission("info:read", app.enrollHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/info/:id/enrollments", app.requirePermission("info:read", app.withdrawHandler))
+	ownMediaType.add(http.MethodGet, "/v1/info/:id/exams.ics")
+	router.HandlerFunc(http.MethodGet, "/v1/info/:id/exams.ics", app.authenticateCalendar(app.requirePermission("info:read", app.moduleExamsCalendarHandler)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/programs", app.requirePermission("info:write", app.createProgramHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/programs", app.requireActivatedUser(app.listProgramsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/programs/:id", app.requireActivatedUser(app.showProgramHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/programs/:id/load", app.requireActivatedUser(app.showProgramLoadHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/programs/:id", app.requirePermission("info:write", app.updateProgramHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/programs/:id", app.requirePermission("info:write", app.deleteProgramHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/exam-sessions", app.requirePermission("info:write", app.createExamSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exam-sessions", app.requireActivatedUser(app.listExamSessionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exam-sessions/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"conflicts": app.requirePermission("info:write", app.listExamConflictsHandler),
+	}, app.requireActivatedUser(app.showExamSessionHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/exam-sessions/:id", app.requirePermission("info:write", app.updateExamSessionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/exam-sessions/:id", app.requirePermission("info:write", app.deleteExamSessionHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.revokeAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.revokeAllAuthenticationTokensHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/users/get", app.requireActivatedUser(app.getAllUserInfoHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/users/get/:id", app.requireActivatedUser(app.getUserInfoHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/users/edit/:id", app.requireAdminRole(app.editUserInfoHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/users/delete/:id", app.requireAdminRole(app.deleteUserInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/get", app.requirePermission("users:read", app.getAllUserInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/get/:id", app.requirePermission("users:read", app.getUserInfoHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/edit/:id", app.requirePermission("users:write", app.editUserInfoHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/delete/:id", app.requirePermission("users:write", app.deleteUserInfoHandler))
+	ownMediaType.add(http.MethodGet, "/v1/users/export")
+	router.HandlerFunc(http.MethodGet, "/v1/users/export", app.requirePermission("users:read", app.exportUserInfoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/trash", app.requirePermission("users:read", app.listDeletedUserInfoHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/restore/:id", app.requirePermission("users:write", app.restoreUserInfoHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/tokens/:id", app.requirePermission("users:write", app.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/lockouts/:id", app.requirePermission("users:read", app.listUserLockoutsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/unlock/:id", app.requirePermission("users:write", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/api-keys/:id", app.requirePermission("users:read", app.listUserAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/api-keys/:id", app.requirePermission("users:write", app.createUserAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", app.requirePermission("users:write", app.revokeAnyAPIKeyHandler))
+
+	ownMediaType.add(http.MethodGet, "/v1/users/me/calendar.ics")
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/calendar.ics", app.authenticateCalendar(app.requireActivatedUser(app.userExamsCalendarHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/calendar-token", app.requireActivatedUser(app.rotateCalendarTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/calendar-token", app.requireActivatedUser(app.revokeCalendarTokenHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.changePasswordHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.requestEmailChangeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requireActivatedUser(app.enrollTwoFactorHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/confirm", app.requireActivatedUser(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/2fa", app.requireActivatedUser(app.disableTwoFactorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.revokeAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.revokeSessionHandler))
+
+	return app.recoverPanic(app.rateLimit(app.negotiateContent(app.authenticate(router), ownMediaType)))
+}
 
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+// httprouter doesn't allow a static path segment such as /v1/info/search to live next to
+// the :id wildcard in the same position. staticSegments works around this by being
+// registered on the wildcard route itself and dispatching on the value of the id
+// parameter, falling back to the regular :id handler (or a 404 if there is none).
+func (app *application) staticSegments(routes map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if handler, ok := routes[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+		if fallback == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		fallback(w, r)
+	}
 }
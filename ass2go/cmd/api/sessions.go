@@ -0,0 +1,117 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenUsageFlushInterval is how often the last-used times of authentication tokens and
+// API keys are written to the database. Session listings can lag behind by up to this
+// long.
+const tokenUsageFlushInterval = time.Minute
+
+// tokenUsage collects the last time each authentication token, or each API key, was
+// used, keyed by its hash, between flushes to the database.
+type tokenUsage struct {
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+func newTokenUsage() *tokenUsage {
+	return &tokenUsage{lastUsed: make(map[string]time.Time)}
+}
+
+func (u *tokenUsage) record(hash []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastUsed[string(hash)] = time.Now()
+}
+
+// take returns the uses collected so far and starts a new batch.
+func (u *tokenUsage) take() map[string]time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	lastUsed := u.lastUsed
+	u.lastUsed = make(map[string]time.Time)
+	return lastUsed
+}
+
+// flushTokenUsage periodically writes the collected last-used times of tokens and API
+// keys to the database, each batch independently of the other. A batch which fails to
+// be written is dropped and the error logged, since the next use of each token or key
+// will record a newer time anyway.
+func (app *application) flushTokenUsage() {
+	ticker := time.NewTicker(tokenUsageFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		lastUsed := app.tokenUsage.take()
+		err := app.models.Tokens.SetLastUsed(lastUsed)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"tokens": strconv.Itoa(len(lastUsed)),
+			})
+		}
+		lastUsed = app.apiKeyUsage.take()
+		err = app.models.APIKeys.SetLastUsed(lastUsed)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"api_keys": strconv.Itoa(len(lastUsed)),
+			})
+		}
+	}
+}
+
+// tokenClient describes the client making the request, to be stored with the tokens
+// issued to it.
+func (app *application) tokenClient(r *http.Request) data.TokenClient {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	return data.TokenClient{IPAddress: ip, UserAgent: userAgent}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	currentHash := data.HashTokenPlaintext(app.readBearerToken(r))
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, currentHash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeSessionHandler signs one of the user's sessions out, which may be the one making
+// the request.
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	user := app.contextGetUser(r)
+	err := app.models.Tokens.DeleteSession(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -25,36 +25,330 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Turn away clients which have already failed to log in too many times, before
+	// spending any time on checking the password.
+	if blocked, retryAfter := app.loginFailures.blocked(app.tokenClient(r).IPAddress); blocked {
+		app.cooldownResponse(w, r, retryAfter)
+		return
+	}
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordFailedLogin(r, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	// While the account is locked the password isn't checked at all, so that guessing
+	// can't carry on in the meantime.
+	lockedUntil, err := app.models.Lockouts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, *lockedUntil)
+		return
+	}
 	// Check if the provided password matches the actual password for the user.
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, count the failure against the account and the
+	// client, then call the app.invalidCredentialsResponse() helper and return.
 	if !match {
+		err = app.recordFailedLogin(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// If the user has two-factor authentication enabled, the password alone isn't
+	// enough. Send back a short-lived token instead, which proves that the password was
+	// right and can be exchanged for an authentication token at POST /v1/tokens/2fa
+	// together with a code.
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor.Enabled {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeResponse(w, r, http.StatusCreated, envelope{"two_factor_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.issueAuthenticationTokens(w, r, user.ID)
+}
+
+// issueAuthenticationTokens sends a short-lived authentication token along with a
+// refresh token which can be exchanged for a new pair at POST /v1/tokens/refresh.
+// Issuing them completes a successful login, so the failed logins counted against the
+// account are cleared first.
+func (app *application) issueAuthenticationTokens(w http.ResponseWriter, r *http.Request, userID int64) {
+	err := app.recordSuccessfulLogin(r, userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	access, refresh, err := app.models.Tokens.NewPair(userID, app.tokenClient(r), app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new authentication
+// token and refresh token. Each refresh token can only be used once.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	access, refresh, err := app.models.Tokens.Rotate(input.RefreshToken, app.tokenClient(r), app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			// The token family has already been revoked. Log it, since it most likely
+			// means that a refresh token has been stolen, but don't tell the client
+			// anything more than for any other invalid token.
+			app.logger.PrintInfo("refresh token reused, token family revoked", map[string]string{
+				"remote_addr": r.RemoteAddr,
+			})
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAuthenticationTokenHandler signs the user out by revoking the authentication
+// token the request was made with, together with the refresh token issued alongside it.
+func (app *application) revokeAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.readBearerToken(r)
+	err := app.models.Tokens.DeleteByHash(data.HashTokenPlaintext(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sessionScopes are the token scopes which let a client act as the user, and which are
+// revoked when all of the user's sessions are.
+var sessionScopes = []string{data.ScopeAuthentication, data.ScopeRefresh}
+
+// revokeAllAuthenticationTokensHandler signs the user out everywhere, including the
+// session the request was made with.
+func (app *application) revokeAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	for _, scope := range sessionScopes {
+		err := app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeUserTokensHandler lets an admin sign any user out everywhere. The user's calendar
+// token is revoked too, but not an outstanding activation token.
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, scope := range append([]string{data.ScopeCalendar}, sessionScopes...) {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "all tokens for the user successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a password reset token to the user with the
+// given email address, replacing any earlier password reset token. The response is the
+// same whether or not there is such a user, so that it can't be used to find out which
+// email addresses are registered, and requests for the same address are subject to a
+// cooldown whether it is registered or not.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if ok, retryAfter := app.passwordResetCooldown.allow(input.Email); !ok {
+		app.cooldownResponse(w, r, retryAfter)
+		return
+	}
+	env := envelope{"message": "if that email address is registered, an email will be sent to it containing password reset instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Only the latest password reset link works.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler sends a fresh activation email, replacing any earlier
+// activation token. As with password resets the response doesn't reveal whether the
+// email address belongs to an account waiting to be activated, and requests for the
+// same address are subject to a cooldown whether it does or not.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if ok, retryAfter := app.activationCooldown.allow(input.Email); !ok {
+		app.cooldownResponse(w, r, retryAfter)
+		return
+	}
+	env := envelope{"message": "if that email address belongs to an account waiting to be activated, an email will be sent to it containing activation instructions"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err == nil && !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		token, err := app.models.Tokens.New(user.ID, app.config.activation.tokenTTL, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			}
+			err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
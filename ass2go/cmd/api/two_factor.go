@@ -0,0 +1,245 @@
+package main
+
+import (
+	"ass2/internal/data"
+	"ass2/internal/totp"
+	"ass2/internal/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// totpIssuer is the name authenticator apps show next to the codes for this API.
+const totpIssuer = "Greenlight"
+
+// checkTwoFactorCode checks a code from the user's authenticator app, or failing that one
+// of their recovery codes. Either kind of code is only accepted once.
+func (app *application) checkTwoFactorCode(userID int64, twoFactor *data.TwoFactor, code string) (bool, error) {
+	if step, ok := totp.Validate(twoFactor.Secret, code, time.Now()); ok {
+		return app.models.TwoFactor.UseStep(userID, step)
+	}
+	return app.models.TwoFactor.UseRecoveryCode(userID, code)
+}
+
+// enrollTwoFactorHandler starts setting up two-factor authentication by generating a new
+// secret. It only takes effect once confirmed with a code from the authenticator app.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TwoFactor.SetPendingSecret(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelope{"secret": secret, "otpauth_uri": totp.URI(totpIssuer, user.Email, secret)}
+	err = app.writeResponse(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler enables two-factor authentication once the user has shown that
+// their authenticator app produces the right codes. The recovery codes are returned in
+// the response, and this is the only time they are ever shown.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if twoFactor.Enabled {
+		app.twoFactorEnabledResponse(w, r)
+		return
+	}
+	if twoFactor.Secret == "" {
+		v.AddError("code", "two-factor authentication must be enrolled in first")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	step, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is not a valid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	codes, hashes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.TwoFactor.Enable(user.ID, step, hashes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorNotPending):
+			app.twoFactorEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler turns two-factor authentication off, which takes a valid code
+// or recovery code so that a stolen session alone can't be used to do it. Wrong codes
+// count as failed logins, so that they can't be guessed either.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	if app.loginLimitsReached(w, r, user) {
+		return
+	}
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !twoFactor.Enabled {
+		app.notFoundResponse(w, r)
+		return
+	}
+	ok, err := app.checkTwoFactorCode(user.ID, twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordFailedLogin(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("code", "is not a valid code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.TwoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTwoFactorAuthenticationTokenHandler completes signing in for a user with
+// two-factor authentication, exchanging the token from POST /v1/tokens/authentication
+// and a code for an authentication token. A wrong code uses up the two-factor token, so
+// that codes can't be guessed without also going through the password check each time.
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// A code is as guessable as a password, so the same limits apply to both.
+	if blocked, retryAfter := app.loginFailures.blocked(app.tokenClient(r).IPAddress); blocked {
+		app.cooldownResponse(w, r, retryAfter)
+		return
+	}
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Tokens.DeleteByHash(data.HashTokenPlaintext(input.TokenPlaintext))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The account may have been locked since the two-factor token was issued, in which
+	// case the token is used up without the code being checked.
+	lockedUntil, err := app.models.Lockouts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if lockedUntil != nil {
+		app.accountLockedResponse(w, r, *lockedUntil)
+		return
+	}
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Two-factor authentication may have been turned off since the token was issued,
+	// leaving no secret to check the code against. The password has to be sent again.
+	if !twoFactor.Enabled || twoFactor.Secret == "" {
+		v.AddError("token", "invalid or expired two-factor token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ok, err := app.checkTwoFactorCode(user.ID, twoFactor, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordFailedLogin(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+	app.issueAuthenticationTokens(w, r, user.ID)
+}
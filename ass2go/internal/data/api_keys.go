@@ -0,0 +1,216 @@
+package data
+
+import (
+	"ass2/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+var (
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// APIKey is a long-lived credential for services which act on behalf of a user. It only
+// carries the permissions it was created with, even if its user has more. The plaintext
+// key is only filled in when the key is created, since only its hash is stored.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"user_id"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	v.Check(key.Expiry == nil || key.Expiry.After(time.Now()), "expiry", "must be in the future")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "api_key", "must be provided")
+	v.Check(len(plaintext) == 26, "api_key", "must be 26 bytes long")
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the key, stores its hash and grants it its permissions, which must
+// all exist or ErrUnknownPermission is returned.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	plaintext, err := randomString()
+	if err != nil {
+		return err
+	}
+	key.Plaintext = plaintext
+	key.Hash = HashTokenPlaintext(plaintext)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+INSERT INTO api_keys (hash, user_id, name, expiry)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+	args := []any{key.Hash, key.UserID, key.Name, key.Expiry}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	query = `
+INSERT INTO api_keys_permissions (api_key_id, permission_id)
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	result, err := tx.ExecContext(ctx, query, key.ID, pq.Array([]string(key.Permissions)))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != int64(len(key.Permissions)) {
+		return ErrUnknownPermission
+	}
+	return tx.Commit()
+}
+
+// GetForKey returns the unexpired API key with the given plaintext, together with its
+// permissions.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	query := `
+SELECT api_keys.id, api_keys.user_id, api_keys.name, api_keys.created_at, api_keys.expiry, api_keys.last_used_at,
+       array_remove(array_agg(permissions.code), NULL)
+FROM api_keys
+LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id
+WHERE api_keys.hash = $1
+AND (api_keys.expiry IS NULL OR api_keys.expiry > NOW())
+GROUP BY api_keys.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key := APIKey{Hash: HashTokenPlaintext(plaintext)}
+	err := m.DB.QueryRowContext(ctx, query, key.Hash).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		pq.Array((*[]string)(&key.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// GetAllForUser returns the user's API keys, including expired ones, newest first.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+SELECT api_keys.id, api_keys.user_id, api_keys.name, api_keys.created_at, api_keys.expiry, api_keys.last_used_at,
+       array_remove(array_agg(permissions.code ORDER BY permissions.code), NULL)
+FROM api_keys
+LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id
+WHERE api_keys.user_id = $1
+GROUP BY api_keys.id
+ORDER BY api_keys.id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsedAt,
+			pq.Array((*[]string)(&key.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete revokes an API key. If userID isn't zero the key must also belong to that user,
+// otherwise ErrRecordNotFound is returned.
+func (m APIKeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM api_keys
+WHERE id = $1 AND (user_id = $2 OR $2 = 0)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetLastUsed records when each of the API keys was last used, keyed by their hash, in
+// the same way as TokenModel.SetLastUsed().
+func (m APIKeyModel) SetLastUsed(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+	hashes := make([][]byte, 0, len(lastUsed))
+	times := make([]string, 0, len(lastUsed))
+	for hash, t := range lastUsed {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, t.UTC().Format(time.RFC3339))
+	}
+	query := `
+UPDATE api_keys
+SET last_used_at = used.at
+FROM unnest($1::bytea[], $2::timestamptz[]) AS used(hash, at)
+WHERE api_keys.hash = used.hash
+AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < used.at)`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.ByteaArray(hashes), pq.Array(times))
+	return err
+}
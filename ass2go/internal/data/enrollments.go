@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	EnrollmentStatusEnrolled   = "enrolled"
+	EnrollmentStatusWaitlisted = "waitlisted"
+)
+
+var (
+	ErrAlreadyEnrolled = errors.New("already enrolled")
+)
+
+// Enrollment is a student's place in a module, or on its waitlist once the module is
+// full. Waitlisted students are promoted in the order they joined. The enrollments of
+// students in the trash are kept in case they are restored, but they don't take up a
+// place or hold up the waitlist in the meantime. A restored student gets their place
+// back even if the module has filled up since.
+type Enrollment struct {
+	ID               int64     `json:"id"`
+	ModuleInfoID     int64     `json:"module_info_id"`
+	UserID           int64     `json:"user_id"`
+	Status           string    `json:"status"`
+	WaitlistPosition int       `json:"waitlist_position,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type EnrollmentModel struct {
+	DB *sql.DB
+}
+
+// lockModule locks the module row for the rest of the transaction and returns its
+// capacity. Every change to the enrollments of a module takes this lock first, which
+// serializes them so that the capacity can't be exceeded by concurrent requests.
+func lockModule(ctx context.Context, tx *sql.Tx, moduleID int64) (int, error) {
+	query := `
+SELECT capacity
+FROM module_info
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE`
+	var capacity int
+	err := tx.QueryRowContext(ctx, query, moduleID).Scan(&capacity)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return capacity, nil
+}
+
+// Enroll gives the user a place in the module if there is one left, or puts them at the
+// back of the waitlist otherwise.
+func (m EnrollmentModel) Enroll(moduleID, userID int64) (*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, err := lockModule(ctx, tx, moduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var enrolled, waitlisted int
+	query := `
+SELECT count(*) FILTER (WHERE status = 'enrolled'), count(*) FILTER (WHERE status = 'waitlisted')
+FROM enrollments
+WHERE module_info_id = $1
+AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+	err = tx.QueryRowContext(ctx, query, moduleID).Scan(&enrolled, &waitlisted)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment := &Enrollment{ModuleInfoID: moduleID, UserID: userID, Status: EnrollmentStatusEnrolled}
+	if enrolled >= capacity {
+		enrollment.Status = EnrollmentStatusWaitlisted
+		enrollment.WaitlistPosition = waitlisted + 1
+	}
+
+	query = `
+INSERT INTO enrollments (module_info_id, user_id, status)
+VALUES ($1, $2, $3)
+ON CONFLICT (module_info_id, user_id) DO NOTHING
+RETURNING id, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, query, moduleID, userID, enrollment.Status).Scan(&enrollment.ID, &enrollment.CreatedAt, &enrollment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAlreadyEnrolled
+		default:
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// Withdraw removes the user from the module or its waitlist. If that frees up a place,
+// the first student on the waitlist is promoted into it and their enrollment returned,
+// so that they can be told about it. Otherwise the returned enrollment is nil.
+func (m EnrollmentModel) Withdraw(moduleID, userID int64) (*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, err := lockModule(ctx, tx, moduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	query := `
+DELETE FROM enrollments
+WHERE module_info_id = $1 AND user_id = $2
+RETURNING status`
+	err = tx.QueryRowContext(ctx, query, moduleID, userID).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var promoted *Enrollment
+	if status == EnrollmentStatusEnrolled {
+		var enrolled int
+		query = `
+SELECT count(*)
+FROM enrollments
+WHERE module_info_id = $1 AND status = 'enrolled'
+AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+		err = tx.QueryRowContext(ctx, query, moduleID).Scan(&enrolled)
+		if err != nil {
+			return nil, err
+		}
+		if enrolled < capacity {
+			promoted, err = promoteFromWaitlist(ctx, tx, moduleID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return promoted, nil
+}
+
+// promoteFromWaitlist moves the longest-waiting student on the waitlist who isn't in the
+// trash into the module. It returns nil if there is no such student.
+func promoteFromWaitlist(ctx context.Context, tx *sql.Tx, moduleID int64) (*Enrollment, error) {
+	query := `
+UPDATE enrollments
+SET status = 'enrolled', updated_at = NOW()
+WHERE id = (
+    SELECT id
+    FROM enrollments
+    WHERE module_info_id = $1 AND status = 'waitlisted'
+    AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+    ORDER BY id
+    LIMIT 1
+)
+RETURNING id, module_info_id, user_id, status, created_at, updated_at`
+	var enrollment Enrollment
+	err := tx.QueryRowContext(ctx, query, moduleID).Scan(
+		&enrollment.ID,
+		&enrollment.ModuleInfoID,
+		&enrollment.UserID,
+		&enrollment.Status,
+		&enrollment.CreatedAt,
+		&enrollment.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return &enrollment, nil
+}
+
+// fillFromWaitlist promotes students off the waitlist, in order, until the module has
+// as many enrolled students as its capacity or the waitlist runs out. The caller must
+// hold the lock on the module row.
+func fillFromWaitlist(ctx context.Context, tx *sql.Tx, moduleID int64, capacity int) ([]*Enrollment, error) {
+	var enrolled int
+	query := `
+SELECT count(*)
+FROM enrollments
+WHERE module_info_id = $1 AND status = 'enrolled'
+AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+	err := tx.QueryRowContext(ctx, query, moduleID).Scan(&enrolled)
+	if err != nil {
+		return nil, err
+	}
+	promoted := []*Enrollment{}
+	for ; enrolled < capacity; enrolled++ {
+		enrollment, err := promoteFromWaitlist(ctx, tx, moduleID)
+		if err != nil {
+			return nil, err
+		}
+		if enrollment == nil {
+			break
+		}
+		promoted = append(promoted, enrollment)
+	}
+	return promoted, nil
+}
+
+// GetAllForModule returns the enrollments of a module, enrolled students first and then
+// the waitlist in order. Students in the trash are left out.
+func (m EnrollmentModel) GetAllForModule(moduleID int64) ([]*Enrollment, error) {
+	query := `
+SELECT id, module_info_id, user_id, status, created_at, updated_at,
+       CASE WHEN status = 'waitlisted'
+           THEN row_number() OVER (PARTITION BY status ORDER BY id)
+           ELSE 0 END
+FROM enrollments
+WHERE module_info_id = $1
+AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+ORDER BY status, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []*Enrollment{}
+	for rows.Next() {
+		var enrollment Enrollment
+		err := rows.Scan(
+			&enrollment.ID,
+			&enrollment.ModuleInfoID,
+			&enrollment.UserID,
+			&enrollment.Status,
+			&enrollment.CreatedAt,
+			&enrollment.UpdatedAt,
+			&enrollment.WaitlistPosition,
+		)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, &enrollment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
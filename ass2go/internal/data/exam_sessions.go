@@ -0,0 +1,420 @@
+package data
+
+import (
+	"ass2/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ExamConflictRoom     = "room"
+	ExamConflictProctor  = "proctor"
+	ExamConflictStudents = "students"
+)
+
+var (
+	ErrRoomConflict = errors.New("room is already booked for an overlapping exam session")
+)
+
+// ExamSession is a sitting of the exam for a module, in a room and supervised by a
+// proctor. Two sessions can never overlap in the same room, unless one of them belongs
+// to a module in the trash, which the database enforces with an exclusion constraint.
+// ProctorID is only nil once the proctor's account has been purged, and a new proctor
+// has to be given the next time the session is saved.
+type ExamSession struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	ModuleInfoID int64     `json:"module_info_id"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	Room         string    `json:"room"`
+	ProctorID    *int64    `json:"proctor_id"`
+	Version      int32     `json:"version"`
+}
+
+// ExamConflict is a pair of exam sessions which overlap in time and share a room, a
+// proctor or students enrolled in both modules. Kinds lists which of these apply.
+type ExamConflict struct {
+	Kinds          []string     `json:"kinds"`
+	Session        *ExamSession `json:"session"`
+	OtherSession   *ExamSession `json:"other_session"`
+	SharedStudents int          `json:"shared_students,omitempty"`
+}
+
+func ValidateExamSession(v *validator.Validator, session *ExamSession) {
+	v.Check(session.ModuleInfoID > 0, "module_info_id", "must be provided")
+	v.Check(!session.StartsAt.IsZero(), "starts_at", "must be provided")
+	v.Check(!session.EndsAt.IsZero(), "ends_at", "must be provided")
+	v.Check(session.EndsAt.After(session.StartsAt), "ends_at", "must be after starts_at")
+	v.Check(session.EndsAt.Sub(session.StartsAt) <= 12*time.Hour, "ends_at", "must be no more than 12 hours after starts_at")
+	v.Check(session.Room != "", "room", "must be provided")
+	v.Check(len(session.Room) <= 100, "room", "must not be more than 100 bytes long")
+	v.Check(session.ProctorID != nil && *session.ProctorID > 0, "proctor_id", "must be provided")
+}
+
+type ExamSessionModel struct {
+	DB *sql.DB
+}
+
+const examSessionColumns = `id, created_at, updated_at, module_info_id, starts_at, ends_at, room, proctor_id, version`
+
+func scanExamSession(row interface{ Scan(...any) error }, session *ExamSession, extra ...any) error {
+	dest := []any{
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.ModuleInfoID,
+		&session.StartsAt,
+		&session.EndsAt,
+		&session.Room,
+		&session.ProctorID,
+		&session.Version,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// Insert adds a new exam session. Its module_deleted flag is set from its module, which
+// is locked until the session is saved, so that the module can't be moved to the trash
+// or restored in between.
+func (m ExamSessionModel) Insert(session *ExamSession) error {
+	query := `
+INSERT INTO exam_sessions (module_info_id, starts_at, ends_at, room, proctor_id, module_deleted)
+VALUES ($1, $2, $3, $4, $5, (SELECT deleted_at IS NOT NULL FROM module_info WHERE id = $1))
+RETURNING id, created_at, updated_at, version`
+	args := []any{session.ModuleInfoID, session.StartsAt, session.EndsAt, session.Room, session.ProctorID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockModuleInfoForShare(ctx, tx, session.ModuleInfoID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.UpdatedAt, &session.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: conflicting key value violates exclusion constraint "exam_sessions_room_overlap"`:
+			return ErrRoomConflict
+		default:
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// lockModuleInfoForShare locks the module's row against changes, including being moved
+// to the trash or restored, until the end of the transaction.
+func lockModuleInfoForShare(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM module_info WHERE id = $1 FOR SHARE`, id)
+	return err
+}
+
+// Get returns an exam session. Sessions of modules in the trash aren't found.
+func (m ExamSessionModel) Get(id int64) (*ExamSession, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT ` + examSessionColumns + `
+FROM exam_sessions
+WHERE id = $1 AND NOT module_deleted`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var session ExamSession
+	err := scanExamSession(m.DB.QueryRowContext(ctx, query, id), &session)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &session, nil
+}
+
+// GetAll returns the exam sessions which overlap the period between from and to,
+// optionally only those of a single module. Sessions of modules in the trash are left
+// out.
+func (m ExamSessionModel) GetAll(moduleID int64, from, to time.Time, filters Filters) ([]*ExamSession, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT %[3]s, count(*) OVER()
+FROM exam_sessions
+WHERE (module_info_id = $1 OR $1 = 0)
+AND starts_at < $3 AND ends_at > $2
+AND module_info_id IN (SELECT id FROM module_info WHERE deleted_at IS NULL)
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection(), examSessionColumns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, moduleID, from, to, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	sessions := []*ExamSession{}
+	for rows.Next() {
+		var session ExamSession
+		err := scanExamSession(rows, &session, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return sessions, metadata, nil
+}
+
+// Update saves the exam session, and sets its module_deleted flag from its module in the
+// same way as Insert().
+func (m ExamSessionModel) Update(session *ExamSession) error {
+	query := `
+UPDATE exam_sessions
+SET module_info_id = $1, starts_at = $2, ends_at = $3, room = $4, proctor_id = $5,
+    module_deleted = (SELECT deleted_at IS NOT NULL FROM module_info WHERE id = $1),
+    updated_at = NOW(), version = version + 1
+WHERE id = $6 AND version = $7
+RETURNING updated_at, version`
+	args := []any{
+		session.ModuleInfoID,
+		session.StartsAt,
+		session.EndsAt,
+		session.Room,
+		session.ProctorID,
+		session.ID,
+		session.Version,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockModuleInfoForShare(ctx, tx, session.ModuleInfoID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&session.UpdatedAt, &session.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: conflicting key value violates exclusion constraint "exam_sessions_room_overlap"`:
+			return ErrRoomConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m ExamSessionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM exam_sessions
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// StudentConflicts returns the other exam sessions which overlap the given one and
+// belong to a module that shares enrolled students with its module. The session doesn't
+// need to have been saved yet, so that conflicts can be checked before it is. Sessions of
+// modules in the trash don't count.
+func (m ExamSessionModel) StudentConflicts(session *ExamSession) ([]*ExamConflict, error) {
+	query := `
+SELECT es.id, es.created_at, es.updated_at, es.module_info_id, es.starts_at, es.ends_at, es.room, es.proctor_id, es.version,
+       count(DISTINCT theirs.user_id)
+FROM exam_sessions es
+INNER JOIN module_info mi ON mi.id = es.module_info_id
+INNER JOIN enrollments theirs ON theirs.module_info_id = es.module_info_id AND theirs.status = 'enrolled'
+INNER JOIN enrollments ours ON ours.user_id = theirs.user_id AND ours.module_info_id = $1 AND ours.status = 'enrolled'
+WHERE es.id <> $2
+AND es.module_info_id <> $1
+AND es.starts_at < $4 AND es.ends_at > $3
+AND mi.deleted_at IS NULL
+GROUP BY es.id
+ORDER BY es.starts_at, es.id`
+	args := []any{session.ModuleInfoID, session.ID, session.StartsAt, session.EndsAt}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := []*ExamConflict{}
+	for rows.Next() {
+		conflict := &ExamConflict{Kinds: []string{ExamConflictStudents}, Session: session, OtherSession: &ExamSession{}}
+		err := scanExamSession(rows, conflict.OtherSession, &conflict.SharedStudents)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// Conflicts returns every pair of exam sessions within the period between from and to
+// which overlap in time and share a room, a proctor or enrolled students. Sessions of
+// the same module are expected to share students, so those pairs are only reported for
+// a shared room or proctor. Sessions of modules in the trash are left out.
+func (m ExamSessionModel) Conflicts(from, to time.Time) ([]*ExamConflict, error) {
+	query := `
+SELECT a.id, a.created_at, a.updated_at, a.module_info_id, a.starts_at, a.ends_at, a.room, a.proctor_id, a.version,
+       b.id, b.created_at, b.updated_at, b.module_info_id, b.starts_at, b.ends_at, b.room, b.proctor_id, b.version,
+       a.room = b.room,
+       COALESCE(a.proctor_id = b.proctor_id, false),
+       shared.students
+FROM exam_sessions a
+INNER JOIN exam_sessions b ON a.id < b.id AND a.starts_at < b.ends_at AND b.starts_at < a.ends_at
+INNER JOIN module_info ma ON ma.id = a.module_info_id AND ma.deleted_at IS NULL
+INNER JOIN module_info mb ON mb.id = b.module_info_id AND mb.deleted_at IS NULL
+CROSS JOIN LATERAL (
+    SELECT CASE WHEN a.module_info_id = b.module_info_id THEN 0 ELSE (
+        SELECT count(DISTINCT ea.user_id)
+        FROM enrollments ea
+        INNER JOIN enrollments eb ON eb.user_id = ea.user_id
+        WHERE ea.module_info_id = a.module_info_id AND ea.status = 'enrolled'
+        AND eb.module_info_id = b.module_info_id AND eb.status = 'enrolled'
+    ) END AS students
+) shared
+WHERE a.starts_at < $2 AND a.ends_at > $1
+AND (a.room = b.room OR a.proctor_id = b.proctor_id OR shared.students > 0)
+ORDER BY a.starts_at, a.id, b.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := []*ExamConflict{}
+	for rows.Next() {
+		var sameRoom, sameProctor bool
+		conflict := &ExamConflict{Kinds: []string{}, Session: &ExamSession{}, OtherSession: &ExamSession{}}
+		other := conflict.OtherSession
+		err := scanExamSession(rows, conflict.Session,
+			&other.ID,
+			&other.CreatedAt,
+			&other.UpdatedAt,
+			&other.ModuleInfoID,
+			&other.StartsAt,
+			&other.EndsAt,
+			&other.Room,
+			&other.ProctorID,
+			&other.Version,
+			&sameRoom,
+			&sameProctor,
+			&conflict.SharedStudents,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if sameRoom {
+			conflict.Kinds = append(conflict.Kinds, ExamConflictRoom)
+		}
+		if sameProctor {
+			conflict.Kinds = append(conflict.Kinds, ExamConflictProctor)
+		}
+		if conflict.SharedStudents > 0 {
+			conflict.Kinds = append(conflict.Kinds, ExamConflictStudents)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// ScheduledExam is an exam session together with the name of its module, as shown in
+// calendar feeds.
+type ScheduledExam struct {
+	ExamSession
+	ModuleName string `json:"module_name"`
+}
+
+// GetScheduleForModule returns every exam session of the module, oldest first.
+func (m ExamSessionModel) GetScheduleForModule(moduleID int64) ([]*ScheduledExam, error) {
+	query := `
+SELECT es.id, es.created_at, es.updated_at, es.module_info_id, es.starts_at, es.ends_at, es.room, es.proctor_id, es.version,
+       mi.module_name
+FROM exam_sessions es
+INNER JOIN module_info mi ON mi.id = es.module_info_id
+WHERE es.module_info_id = $1 AND mi.deleted_at IS NULL
+ORDER BY es.starts_at, es.id`
+	return m.getSchedule(query, moduleID)
+}
+
+// GetScheduleForUser returns the exam sessions of every module the user is enrolled in,
+// oldest first. Modules the user is only waitlisted for are left out.
+func (m ExamSessionModel) GetScheduleForUser(userID int64) ([]*ScheduledExam, error) {
+	query := `
+SELECT es.id, es.created_at, es.updated_at, es.module_info_id, es.starts_at, es.ends_at, es.room, es.proctor_id, es.version,
+       mi.module_name
+FROM exam_sessions es
+INNER JOIN module_info mi ON mi.id = es.module_info_id
+INNER JOIN enrollments e ON e.module_info_id = es.module_info_id
+WHERE e.user_id = $1 AND e.status = 'enrolled' AND mi.deleted_at IS NULL
+ORDER BY es.starts_at, es.id`
+	return m.getSchedule(query, userID)
+}
+
+func (m ExamSessionModel) getSchedule(query string, args ...any) ([]*ScheduledExam, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exams := []*ScheduledExam{}
+	for rows.Next() {
+		var exam ScheduledExam
+		err := scanExamSession(rows, &exam.ExamSession, &exam.ModuleName)
+		if err != nil {
+			return nil, err
+		}
+		exams = append(exams, &exam)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return exams, nil
+}
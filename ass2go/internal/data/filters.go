@@ -0,0 +1,159 @@
+package data
+
+import (
+	"ass2/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+	Cursor       string
+}
+
+// Cursor identifies the last row of a page in keyset pagination mode. It holds the sort
+// parameter the page was produced with, the value of the sort column for that row and
+// its id, which breaks ties between rows with equal sort values. Clients only ever see
+// it in its encoded, opaque form.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(js, &c); err != nil || c.ID < 1 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	if f.Cursor != "" {
+		c, err := DecodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor value")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "must be used with the same sort value it was issued for")
+	}
+}
+
+// sortColumn checks that the client-provided Sort field matches one of the entries in
+// the safelist and if it does, extracts the column name from it by stripping the
+// leading hyphen character (if one exists). The panic is a sensible failsafe to help
+// stop a SQL injection attack in case ValidateFilters() wasn't called.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// cursorComparator returns the row comparison operator which selects the rows following
+// a cursor, given the sort direction.
+func (f Filters) cursorComparator() string {
+	if f.sortDirection() == "DESC" {
+		return "<"
+	}
+	return ">"
+}
+
+// cursor decodes the Cursor field. It must only be called after ValidateFilters() has
+// accepted the value.
+func (f Filters) cursor() Cursor {
+	c, err := DecodeCursor(f.Cursor)
+	if err != nil {
+		panic("unsafe cursor parameter: " + f.Cursor)
+	}
+	return c
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// likeEscaper escapes the characters which have a special meaning in LIKE patterns, so
+// that user input can be matched literally with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// calculateMetadata works out the pagination metadata values given the total number of
+// records, current page, and page size values. If there are no records we return an
+// empty Metadata struct.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// calculateCursorMetadata returns the metadata for a keyset page. Counting the total
+// number of records would defeat the purpose of keyset pagination, so only the page size
+// and the cursor for the following page are reported.
+func calculateCursorMetadata(filters Filters, count int, lastValue string, lastID int64) Metadata {
+	return Metadata{
+		PageSize:   filters.PageSize,
+		NextCursor: nextCursor(filters, count, lastValue, lastID),
+	}
+}
+
+// nextCursor returns the encoded cursor pointing after the last row of a page, or the
+// empty string if the page wasn't full and so there are no more rows to fetch.
+func nextCursor(filters Filters, count int, lastValue string, lastID int64) string {
+	if count == 0 || count < filters.limit() {
+		return ""
+	}
+	return Cursor{Sort: filters.Sort, Value: lastValue, ID: lastID}.Encode()
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LockoutPolicy decides when an account is locked after failed logins and for how long.
+// Each lock lasts twice as long as the previous one, up to MaxDuration, until the user
+// signs in successfully.
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// Duration returns how long the account is locked for, given how many times it has
+// already been locked since the last successful login.
+func (p LockoutPolicy) Duration(previousLockouts int) time.Duration {
+	duration := p.BaseDuration
+	for i := 0; i < previousLockouts && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > p.MaxDuration {
+		duration = p.MaxDuration
+	}
+	return duration
+}
+
+// Lockout is a record of an account having been locked.
+type Lockout struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LockedUntil    time.Time  `json:"locked_until"`
+	FailedAttempts int        `json:"failed_attempts"`
+	IPAddress      string     `json:"ip_address"`
+	UnlockedAt     *time.Time `json:"unlocked_at,omitempty"`
+	UnlockedBy     *int64     `json:"unlocked_by,omitempty"`
+}
+
+type LockoutModel struct {
+	DB *sql.DB
+}
+
+// LockedUntil returns the time the user's account is locked until, or nil if it isn't
+// locked.
+func (m LockoutModel) LockedUntil(userID int64) (*time.Time, error) {
+	query := `
+SELECT locked_until
+FROM users
+WHERE id = $1 AND locked_until > NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var lockedUntil time.Time
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return &lockedUntil, nil
+}
+
+// RecordFailure counts a failed login for the user. Once the failures reach the policy's
+// threshold the account is locked, the lock recorded and the failure count started
+// again, and the new lockout is returned. Otherwise the returned lockout is nil.
+func (m LockoutModel) RecordFailure(userID int64, ipAddress string, policy LockoutPolicy) (*Lockout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE users
+SET failed_logins = failed_logins + 1
+WHERE id = $1
+RETURNING failed_logins, lockouts`
+	var failedLogins, lockouts int
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&failedLogins, &lockouts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if failedLogins < policy.Threshold {
+		return nil, tx.Commit()
+	}
+
+	lockout := &Lockout{
+		UserID:         userID,
+		LockedUntil:    time.Now().Add(policy.Duration(lockouts)),
+		FailedAttempts: failedLogins,
+		IPAddress:      ipAddress,
+	}
+	query = `
+UPDATE users
+SET failed_logins = 0, lockouts = lockouts + 1, locked_until = $1
+WHERE id = $2`
+	_, err = tx.ExecContext(ctx, query, lockout.LockedUntil, userID)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+INSERT INTO account_lockouts (user_id, locked_until, failed_attempts, ip_address)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+	args := []any{lockout.UserID, lockout.LockedUntil, lockout.FailedAttempts, lockout.IPAddress}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&lockout.ID, &lockout.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return lockout, nil
+}
+
+// Reset clears the user's failed logins and previous lockouts after a successful login.
+func (m LockoutModel) Reset(userID int64) error {
+	query := `
+UPDATE users
+SET failed_logins = 0, lockouts = 0
+WHERE id = $1 AND (failed_logins > 0 OR lockouts > 0)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Unlock lifts the lock on the user's account on behalf of the admin with the given ID,
+// clears its failed logins and marks any lockouts still in force as unlocked.
+func (m LockoutModel) Unlock(userID, adminID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE users
+SET failed_logins = 0, lockouts = 0, locked_until = NULL
+WHERE id = $1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	query = `
+UPDATE account_lockouts
+SET unlocked_at = NOW(), unlocked_by = $1
+WHERE user_id = $2 AND locked_until > NOW() AND unlocked_at IS NULL`
+	_, err = tx.ExecContext(ctx, query, adminID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAllForUser returns the lockouts of the user's account, most recent first.
+func (m LockoutModel) GetAllForUser(userID int64) ([]*Lockout, error) {
+	query := `
+SELECT id, user_id, created_at, locked_until, failed_attempts, ip_address, unlocked_at, unlocked_by
+FROM account_lockouts
+WHERE user_id = $1
+ORDER BY id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*Lockout{}
+	for rows.Next() {
+		var lockout Lockout
+		err := rows.Scan(
+			&lockout.ID,
+			&lockout.UserID,
+			&lockout.CreatedAt,
+			&lockout.LockedUntil,
+			&lockout.FailedAttempts,
+			&lockout.IPAddress,
+			&lockout.UnlockedAt,
+			&lockout.UnlockedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, &lockout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
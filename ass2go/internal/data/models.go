@@ -11,17 +11,29 @@ var (
 )
 
 type Models struct {
-	Users       UserModel
-	Tokens      TokenModel
-	InfoModel   ModuleInfoModel
-	Permissions PermissionModel
+	Users        UserModel
+	Tokens       TokenModel
+	InfoModel    ModuleInfoModel
+	Permissions  PermissionModel
+	Programs     ProgramModel
+	Enrollments  EnrollmentModel
+	ExamSessions ExamSessionModel
+	TwoFactor    TwoFactorModel
+	Lockouts     LockoutModel
+	APIKeys      APIKeyModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		InfoModel:   ModuleInfoModel{DB: db},
+		Users:        UserModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		InfoModel:    ModuleInfoModel{DB: db},
+		Programs:     ProgramModel{DB: db},
+		Enrollments:  EnrollmentModel{DB: db},
+		ExamSessions: ExamSessionModel{DB: db},
+		TwoFactor:    TwoFactorModel{DB: db},
+		Lockouts:     LockoutModel{DB: db},
+		APIKeys:      APIKeyModel{DB: db},
 	}
 }
@@ -2,40 +2,123 @@ package data
 
 import (
 	"ass2/internal/validator"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 )
 
+// DefaultModuleCapacity is the number of students who can enroll in a module when no
+// capacity is given for it.
+const DefaultModuleCapacity = 30
+
 type ModuleInfo struct {
-	ID             int64     `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	ModuleName     string    `json:"module_name"`
-	ModuleDuration int       `json:"module_duration"`
-	ExamType       string    `json:"exam_type"`
-	Version        int32     `json:"version"`
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ModuleName     string     `json:"module_name"`
+	ModuleDuration int        `json:"module_duration"`
+	ExamType       string     `json:"exam_type"`
+	Capacity       int        `json:"capacity"`
+	Version        int32      `json:"version"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ModuleSearchResult is a module matched by a full-text search, together with its
+// relevance and a snippet of the matching text with the search terms highlighted.
+type ModuleSearchResult struct {
+	ModuleInfo
+	Rank     float32 `json:"rank"`
+	Headline string  `json:"headline"`
 }
 
+// ValidateModuleInfo checks the module against the same limits as the module_info
+// table, so a module that passes can always be inserted.
 func ValidateModuleInfo(v *validator.Validator, module *ModuleInfo) {
 	v.Check(module.ModuleName != "", "module_name", "must be provided")
-	v.Check(len(module.ModuleName) <= 500, "module_name", "must not be more than 500 bytes long")
+	v.Check(len(module.ModuleName) <= 255, "module_name", "must not be more than 255 bytes long")
 	v.Check(module.ModuleDuration != 0, "module_duration", "must be provided")
-	v.Check(module.ModuleDuration > 0, "module_duration", "must be a positive integer")
+	v.Check(module.ModuleDuration > 5 && module.ModuleDuration <= 15, "module_duration", "must be more than 5 and not more than 15")
 	v.Check(module.ExamType != "", "exam_type", "must be provided")
+	v.Check(len(module.ExamType) <= 255, "exam_type", "must not be more than 255 bytes long")
+	v.Check(module.Capacity > 0, "capacity", "must be a positive integer")
+	v.Check(module.Capacity <= 10_000, "capacity", "must not be more than 10000")
+}
+
+// ApplyJSONPatch applies a set of RFC 6902 operations to the module. Only the
+// module_name, module_duration, exam_type and capacity members can be changed. The
+// result still needs to be checked with ValidateModuleInfo() before it is saved.
+func (module *ModuleInfo) ApplyJSONPatch(ops []PatchOperation) error {
+	return applyJSONPatch(module, ops, "module_name", "module_duration", "exam_type", "capacity")
 }
 
 type ModuleInfoModel struct {
 	DB *sql.DB
 }
 
-func (m ModuleInfoModel) Insert(module *ModuleInfo) error {
+// Insert adds a new module and records the insert in its history, on behalf of the
+// user with the given ID.
+func (m ModuleInfoModel) Insert(module *ModuleInfo, userID int64) error {
 	query := `
-		INSERT INTO module_info (module_name, module_duration, exam_type)
-		VALUES ($1, $2, $3)
-		RETURNING id, created_at, version`
-	args := []interface{}{module.ModuleName, module.ModuleDuration, module.ExamType}
-	return m.DB.QueryRow(query, args...).Scan(&module.ID, &module.CreatedAt, &module.Version)
+		INSERT INTO module_info (module_name, module_duration, exam_type, capacity)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+	args := []interface{}{module.ModuleName, module.ModuleDuration, module.ExamType, module.Capacity}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&module.ID, &module.CreatedAt, &module.UpdatedAt, &module.Version)
+	if err != nil {
+		return err
+	}
+	err = insertModuleInfoHistory(ctx, tx, userID, ModuleInfoActionInsert, nil, module)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertMany adds all the modules in a single transaction, recording each insert in its
+// history, so that either all of them are saved or none are. If dryRun is set the
+// transaction is always rolled back, which checks that the inserts would succeed
+// without saving anything.
+func (m ModuleInfoModel) InsertMany(modules []*ModuleInfo, userID int64, dryRun bool) error {
+	query := `
+		INSERT INTO module_info (module_name, module_duration, exam_type, capacity)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, module := range modules {
+		args := []interface{}{module.ModuleName, module.ModuleDuration, module.ExamType, module.Capacity}
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&module.ID, &module.CreatedAt, &module.UpdatedAt, &module.Version)
+		if err != nil {
+			return err
+		}
+		err = insertModuleInfoHistory(ctx, tx, userID, ModuleInfoActionInsert, nil, module)
+		if err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	return tx.Commit()
 }
 
 func (m ModuleInfoModel) Get(id int64) (*ModuleInfo, error) {
@@ -43,16 +126,18 @@ func (m ModuleInfoModel) Get(id int64) (*ModuleInfo, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-SELECT id, created_at, module_name, module_duration, exam_type, version
+SELECT id, created_at, updated_at, module_name, module_duration, exam_type, capacity, version
 FROM module_info
-WHERE id = $1`
+WHERE id = $1 AND deleted_at IS NULL`
 	var module ModuleInfo
 	err := m.DB.QueryRow(query, id).Scan(
 		&module.ID,
 		&module.CreatedAt,
+		&module.UpdatedAt,
 		&module.ModuleName,
 		&module.ModuleDuration,
 		&module.ExamType,
+		&module.Capacity,
 		&module.Version,
 	)
 	if err != nil {
@@ -66,73 +151,485 @@ WHERE id = $1`
 	return &module, nil
 }
 
-func (m ModuleInfoModel) Update(module *ModuleInfo) error {
+// Update saves the changes to a module, provided that nobody else has changed it since
+// it was fetched, and records them in its history on behalf of the user with the given
+// ID. If the capacity went up, students are promoted off the waitlist into the new
+// places, and their enrollments returned so that they can be told about it.
+func (m ModuleInfoModel) Update(module *ModuleInfo, userID int64) ([]*Enrollment, error) {
+	return m.update(module, userID, ModuleInfoActionUpdate)
+}
+
+// Revert is the same as Update, except that the change is recorded in the history as a
+// revert to an earlier revision.
+func (m ModuleInfoModel) Revert(module *ModuleInfo, userID int64) ([]*Enrollment, error) {
+	return m.update(module, userID, ModuleInfoActionRevert)
+}
+
+func (m ModuleInfoModel) update(module *ModuleInfo, userID int64, action string) ([]*Enrollment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row and read the values it holds before the update, for the history
+	// entry. If it has moved on to another version (or been deleted) in the meantime,
+	// then this is an edit conflict.
+	before, err := getModuleInfoForUpdate(ctx, tx, module.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+	if before.Version != module.Version {
+		return nil, ErrEditConflict
+	}
+
 	query := `
 UPDATE module_info
-SET module_name = $1, module_duration = $2, exam_type = $3, updated_at = NOW(), version = version + 1
-WHERE id = $4
+SET module_name = $1, module_duration = $2, exam_type = $3, capacity = $4, updated_at = NOW(), version = version + 1
+WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 RETURNING updated_at, version`
 	args := []interface{}{
 		module.ModuleName,
 		module.ModuleDuration,
 		module.ExamType,
+		module.Capacity,
 		module.ID,
+		module.Version,
 	}
-	return m.DB.QueryRow(query, args...).Scan(&module.UpdatedAt, &module.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&module.UpdatedAt, &module.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+	err = insertModuleInfoHistory(ctx, tx, userID, action, before, module)
+	if err != nil {
+		return nil, err
+	}
+	// The module row is already locked, which keeps enrollments from changing while
+	// the new places are filled.
+	var promoted []*Enrollment
+	if module.Capacity > before.Capacity {
+		promoted, err = fillFromWaitlist(ctx, tx, module.ID, module.Capacity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return promoted, nil
 }
 
-func (m ModuleInfoModel) Delete(id int64) error {
+// Delete moves a module to the trash by setting its deleted_at timestamp. It stays
+// there, hidden from every other read, until it is restored or purged. Its exam sessions
+// stop holding their rooms in the meantime.
+func (m ModuleInfoModel) Delete(id int64, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getModuleInfoForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
 	query := `
-DELETE FROM module_info
-WHERE id = $1`
-	result, err := m.DB.Exec(query, id)
+UPDATE module_info
+SET deleted_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL`
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	_, err = tx.ExecContext(ctx, `UPDATE exam_sessions SET module_deleted = true WHERE module_info_id = $1`, id)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = insertModuleInfoHistory(ctx, tx, userID, ModuleInfoActionDelete, before, nil)
+	if err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (m ModuleInfoModel) GetAll() ([]*ModuleInfo, error) {
+// getModuleInfoForUpdate fetches a module which isn't in the trash and locks its row
+// until the end of the transaction.
+func getModuleInfoForUpdate(ctx context.Context, tx *sql.Tx, id int64) (*ModuleInfo, error) {
 	query := `
-SELECT id, created_at, module_name, module_duration, exam_type, version
-FROM module_info`
+SELECT id, created_at, updated_at, module_name, module_duration, exam_type, capacity, version
+FROM module_info
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE`
+	var module ModuleInfo
+	err := tx.QueryRowContext(ctx, query, id).Scan(
+		&module.ID,
+		&module.CreatedAt,
+		&module.UpdatedAt,
+		&module.ModuleName,
+		&module.ModuleDuration,
+		&module.ExamType,
+		&module.Capacity,
+		&module.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &module, nil
+}
+
+func (m ModuleInfoModel) GetAll(moduleName, examType string, filters Filters) ([]*ModuleInfo, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, module_name, module_duration, exam_type, capacity, version
+FROM module_info
+WHERE (module_name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+AND (LOWER(exam_type) = LOWER($2) OR $2 = '')
+AND deleted_at IS NULL
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
-	rows, err := m.DB.Query(query)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := []any{escapeLike(moduleName), examType, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	modules := []*ModuleInfo{}
 	for rows.Next() {
 		var module ModuleInfo
 		err := rows.Scan(
+			&totalRecords,
 			&module.ID,
 			&module.CreatedAt,
 			&module.ModuleName,
 			&module.ModuleDuration,
 			&module.ExamType,
+			&module.Capacity,
 			&module.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
+		}
+		modules = append(modules, &module)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if len(modules) > 0 {
+		last := modules[len(modules)-1]
+		metadata.NextCursor = nextCursor(filters, len(modules), last.sortValue(filters.sortColumn()), last.ID)
+	}
+	return modules, metadata, nil
+}
+
+// GetAllAfterCursor is the keyset pagination counterpart of GetAll(). Rather than
+// skipping over an offset, it seeks straight to the rows following the (sort value, id)
+// pair held in filters.Cursor, so its cost doesn't grow with the page number and rows
+// inserted mid-way through paging are neither skipped nor repeated.
+func (m ModuleInfoModel) GetAllAfterCursor(moduleName, examType string, filters Filters) ([]*ModuleInfo, Metadata, error) {
+	cursor := filters.cursor()
+	query := fmt.Sprintf(`
+SELECT id, created_at, module_name, module_duration, exam_type, capacity, version
+FROM module_info
+WHERE (module_name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+AND (LOWER(exam_type) = LOWER($2) OR $2 = '')
+AND deleted_at IS NULL
+AND (%[1]s, id) %[3]s ($3, $4)
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $5`, filters.sortColumn(), filters.sortDirection(), filters.cursorComparator())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := []any{escapeLike(moduleName), examType, cursor.Value, cursor.ID, filters.limit()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	modules := []*ModuleInfo{}
+	for rows.Next() {
+		var module ModuleInfo
+		err := rows.Scan(
+			&module.ID,
+			&module.CreatedAt,
+			&module.ModuleName,
+			&module.ModuleDuration,
+			&module.ExamType,
+			&module.Capacity,
+			&module.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
 		}
 		modules = append(modules, &module)
 	}
 
 	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	var metadata Metadata
+	if len(modules) > 0 {
+		last := modules[len(modules)-1]
+		metadata = calculateCursorMetadata(filters, len(modules), last.sortValue(filters.sortColumn()), last.ID)
+	}
+	return modules, metadata, nil
+}
+
+// GetAllDeleted returns the modules which are currently in the trash.
+func (m ModuleInfoModel) GetAllDeleted(filters Filters) ([]*ModuleInfo, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, updated_at, module_name, module_duration, exam_type, capacity, version, deleted_at
+FROM module_info
+WHERE deleted_at IS NOT NULL
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	modules := []*ModuleInfo{}
+	for rows.Next() {
+		var module ModuleInfo
+		err := rows.Scan(
+			&totalRecords,
+			&module.ID,
+			&module.CreatedAt,
+			&module.UpdatedAt,
+			&module.ModuleName,
+			&module.ModuleDuration,
+			&module.ExamType,
+			&module.Capacity,
+			&module.Version,
+			&module.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		modules = append(modules, &module)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return modules, metadata, nil
+}
+
+// Restore takes a module back out of the trash. The version is bumped so that any
+// copies fetched before it was deleted are treated as stale. Its exam sessions take
+// their rooms back, and ErrRoomConflict is returned if one of them has been booked for
+// an overlapping session since.
+func (m ModuleInfoModel) Restore(id int64, userID int64) (*ModuleInfo, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+UPDATE module_info
+SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, created_at, updated_at, module_name, module_duration, exam_type, capacity, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	return modules, nil
+	var module ModuleInfo
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&module.ID,
+		&module.CreatedAt,
+		&module.UpdatedAt,
+		&module.ModuleName,
+		&module.ModuleDuration,
+		&module.ExamType,
+		&module.Capacity,
+		&module.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE exam_sessions SET module_deleted = false WHERE module_info_id = $1`, id)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: conflicting key value violates exclusion constraint "exam_sessions_room_overlap"`:
+			return nil, ErrRoomConflict
+		default:
+			return nil, err
+		}
+	}
+	err = insertModuleInfoHistory(ctx, tx, userID, ModuleInfoActionRestore, nil, &module)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &module, nil
+}
+
+// Purge permanently deletes the modules which have been in the trash for longer than
+// the retention period, and returns how many there were.
+func (m ModuleInfoModel) Purge(retention time.Duration) (int64, error) {
+	query := `
+DELETE FROM module_info
+WHERE deleted_at < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Search runs a full-text search over the module catalog using the search tsvector
+// column, which is kept up to date by a trigger. The query is parsed with
+// websearch_to_tsquery() so that clients can use quoted phrases, "or" and "-" just like
+// they would in a web search engine.
+func (m ModuleInfoModel) Search(q string, filters Filters) ([]*ModuleSearchResult, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, module_name, module_duration, exam_type, capacity, version,
+       ts_rank(search, query) AS rank,
+       ts_headline('english', module_name || ' ' || exam_type, query, 'StartSel=<mark>, StopSel=</mark>') AS headline
+FROM module_info, websearch_to_tsquery('english', $1) query
+WHERE search @@ query AND deleted_at IS NULL
+ORDER BY %[1]s %[2]s, id ASC
+LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, q, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*ModuleSearchResult{}
+	for rows.Next() {
+		var result ModuleSearchResult
+		err := rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.CreatedAt,
+			&result.ModuleName,
+			&result.ModuleDuration,
+			&result.ExamType,
+			&result.Capacity,
+			&result.Version,
+			&result.Rank,
+			&result.Headline,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
+}
+
+// sortValue returns the value of the given sort column for the module, formatted so that
+// PostgreSQL can compare it against the column when it is passed back in a cursor.
+func (module *ModuleInfo) sortValue(column string) string {
+	switch column {
+	case "module_name":
+		return module.ModuleName
+	case "module_duration":
+		return strconv.Itoa(module.ModuleDuration)
+	case "created_at":
+		return module.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return strconv.FormatInt(module.ID, 10)
+	}
+}
+
+// Export calls fn for every module in the catalog, in id order, while reading them from
+// the database. Rows are never collected in memory, so the catalog can be streamed out
+// whatever its size. The same ModuleInfo is reused for every row, so fn must not hold on
+// to it. The context should be the request's, so that the query is cancelled if the
+// client goes away.
+func (m ModuleInfoModel) Export(ctx context.Context, fn func(*ModuleInfo) error) error {
+	query := `
+SELECT id, created_at, updated_at, module_name, module_duration, exam_type, capacity, version
+FROM module_info
+WHERE deleted_at IS NULL
+ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var module ModuleInfo
+	for rows.Next() {
+		err := rows.Scan(
+			&module.ID,
+			&module.CreatedAt,
+			&module.UpdatedAt,
+			&module.ModuleName,
+			&module.ModuleDuration,
+			&module.ExamType,
+			&module.Capacity,
+			&module.Version,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(&module); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
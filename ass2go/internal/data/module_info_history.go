@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	ModuleInfoActionInsert  = "insert"
+	ModuleInfoActionUpdate  = "update"
+	ModuleInfoActionDelete  = "delete"
+	ModuleInfoActionRestore = "restore"
+	ModuleInfoActionRevert  = "revert"
+)
+
+// ModuleInfoRevision is a single entry in the change history of a module. Before and
+// After hold the module as it was on either side of the change; Before is nil for an
+// insert or restore and After is nil for a delete.
+type ModuleInfoRevision struct {
+	ID           int64       `json:"id"`
+	ModuleInfoID int64       `json:"module_info_id"`
+	UserID       *int64      `json:"user_id"`
+	Action       string      `json:"action"`
+	Version      int32       `json:"version"`
+	Before       *ModuleInfo `json:"before"`
+	After        *ModuleInfo `json:"after"`
+	ChangedAt    time.Time   `json:"changed_at"`
+}
+
+// insertModuleInfoHistory records a change to a module as part of the transaction which
+// makes the change, so that the history can never disagree with the table.
+func insertModuleInfoHistory(ctx context.Context, tx *sql.Tx, userID int64, action string, before, after *ModuleInfo) error {
+	current := after
+	if current == nil {
+		current = before
+	}
+	beforeJSON, err := marshalModuleInfoSnapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalModuleInfoSnapshot(after)
+	if err != nil {
+		return err
+	}
+	query := `
+INSERT INTO module_info_history (module_info_id, user_id, action, version, before, after)
+VALUES ($1, $2, $3, $4, $5, $6)`
+	args := []any{current.ID, userID, action, current.Version, beforeJSON, afterJSON}
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// marshalModuleInfoSnapshot encodes a module for a jsonb column. It returns a string
+// rather than a []byte, since pq would otherwise send the value as bytea.
+func marshalModuleInfoSnapshot(module *ModuleInfo) (any, error) {
+	if module == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(module)
+	if err != nil {
+		return nil, err
+	}
+	return string(js), nil
+}
+
+// History returns the change history of a module, oldest first. Modules in the trash,
+// and modules purged from it, still have a history, so that admins can see who deleted
+// them.
+func (m ModuleInfoModel) History(moduleID int64) ([]*ModuleInfoRevision, error) {
+	if moduleID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, module_info_id, user_id, action, version, before, after, changed_at
+FROM module_info_history
+WHERE module_info_id = $1
+ORDER BY id ASC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, moduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*ModuleInfoRevision{}
+	for rows.Next() {
+		revision, err := scanModuleInfoRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a single revision of a module.
+func (m ModuleInfoModel) GetRevision(moduleID, revisionID int64) (*ModuleInfoRevision, error) {
+	if moduleID < 1 || revisionID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, module_info_id, user_id, action, version, before, after, changed_at
+FROM module_info_history
+WHERE module_info_id = $1 AND id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	revision, err := scanModuleInfoRevision(m.DB.QueryRowContext(ctx, query, moduleID, revisionID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return revision, nil
+}
+
+func scanModuleInfoRevision(row interface{ Scan(...any) error }) (*ModuleInfoRevision, error) {
+	var revision ModuleInfoRevision
+	var before, after []byte
+	err := row.Scan(
+		&revision.ID,
+		&revision.ModuleInfoID,
+		&revision.UserID,
+		&revision.Action,
+		&revision.Version,
+		&before,
+		&after,
+		&revision.ChangedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if before != nil {
+		revision.Before = &ModuleInfo{}
+		if err := json.Unmarshal(before, revision.Before); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		revision.After = &ModuleInfo{}
+		if err := json.Unmarshal(after, revision.After); err != nil {
+			return nil, err
+		}
+	}
+	return &revision, nil
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"ass2/internal/validator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrPatchTestFailed = errors.New("patch test operation failed")
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation. Only the add, replace,
+// remove and test operations are supported, and only on the top-level members of a
+// record, which is all that our flat records need.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies the operations in order to the JSON representation of dst and
+// decodes the result back into it. Members which aren't listed in writable can be
+// tested but never changed. If any operation fails, dst is left untouched.
+func applyJSONPatch(dst any, ops []PatchOperation, writable ...string) error {
+	js, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	var doc map[string]json.RawMessage
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		key, err := patchPathKey(op.Path)
+		if err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+		current, exists := doc[key]
+
+		switch op.Op {
+		case "test":
+			if !exists || !jsonEqual(current, op.Value) {
+				return ErrPatchTestFailed
+			}
+			continue
+		case "add", "replace", "remove":
+		default:
+			return fmt.Errorf("operation %d: unsupported op %q", i, op.Op)
+		}
+
+		if !exists {
+			return fmt.Errorf("operation %d: path %q does not exist", i, op.Path)
+		}
+		if !validator.PermittedValue(key, writable...) {
+			return fmt.Errorf("operation %d: path %q is read-only", i, op.Path)
+		}
+		if op.Op == "remove" {
+			delete(doc, key)
+			continue
+		}
+		if len(op.Value) == 0 {
+			return fmt.Errorf("operation %d: value must be provided", i)
+		}
+		doc[key] = op.Value
+	}
+
+	js, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// Decode into a fresh value of the same type so that removed members end up with
+	// their zero value, rather than keeping whatever dst held before.
+	patched := reflect.New(reflect.TypeOf(dst).Elem())
+	err = json.Unmarshal(js, patched.Interface())
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		if errors.As(err, &unmarshalTypeError) {
+			return fmt.Errorf("incorrect JSON type for field %q", unmarshalTypeError.Field)
+		}
+		return err
+	}
+	reflect.ValueOf(dst).Elem().Set(patched.Elem())
+	return nil
+}
+
+// patchPathKey converts a JSON Pointer which refers to a top-level member into the
+// member name, undoing the ~1 and ~0 escapes.
+func patchPathKey(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") || strings.Count(path, "/") != 1 {
+		return "", fmt.Errorf("unsupported path %q", path)
+	}
+	key := strings.TrimPrefix(path, "/")
+	key = strings.ReplaceAll(key, "~1", "/")
+	key = strings.ReplaceAll(key, "~0", "~")
+	return key, nil
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
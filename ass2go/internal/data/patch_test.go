@@ -0,0 +1,122 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type patchRecord struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	Slashed string `json:"a/b~c"`
+}
+
+func patchOps(t *testing.T, js string) []PatchOperation {
+	t.Helper()
+	var ops []PatchOperation
+	err := json.Unmarshal([]byte(js), &ops)
+	if err != nil {
+		t.Fatalf("invalid operations %s: %v", js, err)
+	}
+	return ops
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	original := patchRecord{ID: 1, Name: "Go", Count: 3, Slashed: "x"}
+	tests := []struct {
+		name string
+		ops  string
+		want patchRecord
+	}{
+		{"replace", `[{"op": "replace", "path": "/name", "value": "Rust"}]`, patchRecord{ID: 1, Name: "Rust", Count: 3, Slashed: "x"}},
+		{"add existing member", `[{"op": "add", "path": "/count", "value": 7}]`, patchRecord{ID: 1, Name: "Go", Count: 7, Slashed: "x"}},
+		{"remove", `[{"op": "remove", "path": "/count"}]`, patchRecord{ID: 1, Name: "Go", Slashed: "x"}},
+		{"replace with null", `[{"op": "replace", "path": "/name", "value": null}]`, patchRecord{ID: 1, Count: 3, Slashed: "x"}},
+		{"escaped path", `[{"op": "replace", "path": "/a~1b~0c", "value": "y"}]`, patchRecord{ID: 1, Name: "Go", Count: 3, Slashed: "y"}},
+		{"test then replace", `[{"op": "test", "path": "/name", "value": "Go"}, {"op": "replace", "path": "/name", "value": "Rust"}]`, patchRecord{ID: 1, Name: "Rust", Count: 3, Slashed: "x"}},
+		{"test read-only member", `[{"op": "test", "path": "/id", "value": 1}]`, original},
+		{"operations in order", `[{"op": "replace", "path": "/count", "value": 4}, {"op": "replace", "path": "/count", "value": 5}]`, patchRecord{ID: 1, Name: "Go", Count: 5, Slashed: "x"}},
+		{"no operations", `[]`, original},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := original
+			err := applyJSONPatch(&record, patchOps(t, tt.ops), "name", "count", "a/b~c")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if record != tt.want {
+				t.Errorf("got %+v, want %+v", record, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	original := patchRecord{ID: 1, Name: "Go", Count: 3}
+	tests := []struct {
+		name    string
+		ops     string
+		wantErr error
+	}{
+		{"test fails", `[{"op": "test", "path": "/name", "value": "Rust"}]`, ErrPatchTestFailed},
+		{"test of missing member", `[{"op": "test", "path": "/missing", "value": 1}]`, ErrPatchTestFailed},
+		{"test fails after a replace", `[{"op": "replace", "path": "/name", "value": "Rust"}, {"op": "test", "path": "/count", "value": 4}]`, ErrPatchTestFailed},
+		{"unsupported op", `[{"op": "move", "from": "/name", "path": "/count"}]`, nil},
+		{"missing op", `[{"path": "/name", "value": "Rust"}]`, nil},
+		{"read-only member", `[{"op": "replace", "path": "/id", "value": 2}]`, nil},
+		{"missing member", `[{"op": "add", "path": "/missing", "value": 1}]`, nil},
+		{"nested path", `[{"op": "replace", "path": "/name/0", "value": "R"}]`, nil},
+		{"relative path", `[{"op": "replace", "path": "name", "value": "Rust"}]`, nil},
+		{"whole document", `[{"op": "replace", "path": "", "value": {}}]`, nil},
+		{"missing value", `[{"op": "replace", "path": "/name"}]`, nil},
+		{"wrong type", `[{"op": "replace", "path": "/count", "value": "four"}]`, nil},
+		{"fails after a replace", `[{"op": "replace", "path": "/name", "value": "Rust"}, {"op": "remove", "path": "/id"}]`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := original
+			err := applyJSONPatch(&record, patchOps(t, tt.ops), "name", "count")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrPatchTestFailed) {
+				t.Errorf("got error %v, want a different one", err)
+			}
+			if record != original {
+				t.Errorf("record changed to %+v despite the error", record)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchTypeError(t *testing.T) {
+	record := patchRecord{Count: 3}
+	err := applyJSONPatch(&record, patchOps(t, `[{"op": "replace", "path": "/count", "value": "four"}]`), "count")
+	if err == nil || err.Error() != `incorrect JSON type for field "count"` {
+		t.Errorf("got error %v, want the field to be named", err)
+	}
+}
+
+func TestModuleInfoApplyJSONPatchWritable(t *testing.T) {
+	for _, path := range []string{"/id", "/created_at", "/updated_at", "/version"} {
+		module := ModuleInfo{ID: 1, Version: 1}
+		ops := []PatchOperation{{Op: "remove", Path: path}}
+		if err := module.ApplyJSONPatch(ops); err == nil {
+			t.Errorf("%s could be removed", path)
+		}
+	}
+	module := ModuleInfo{ID: 1, ModuleName: "Go", Version: 1}
+	ops := []PatchOperation{{Op: "replace", Path: "/capacity", Value: json.RawMessage(`40`)}}
+	if err := module.ApplyJSONPatch(ops); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if module.Capacity != 40 || module.ModuleName != "Go" || module.ID != 1 {
+		t.Errorf("got %+v", module)
+	}
+}
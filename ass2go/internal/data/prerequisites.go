@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/lib/pq"
+	"sort"
+	"time"
+)
+
+var (
+	ErrPrerequisiteCycle = errors.New("prerequisite cycle")
+)
+
+// PrerequisiteNode is a module together with the modules it directly requires, each of
+// which carries its own prerequisites in turn. A module required along several paths
+// only has its prerequisites listed the first time it appears. Every later node for it
+// is marked as Repeated and has none, so that the tree grows with the size of the graph
+// rather than with the number of paths through it.
+type PrerequisiteNode struct {
+	Module        *ModuleInfo         `json:"module"`
+	Repeated      bool                `json:"repeated,omitempty"`
+	Prerequisites []*PrerequisiteNode `json:"prerequisites"`
+}
+
+// AddPrerequisite records that the module requires prerequisiteID to be taken first. The
+// edge is rejected with ErrPrerequisiteCycle if the prerequisite already requires the
+// module, directly or transitively, since the curriculum could never be completed.
+func (m ModuleInfoModel) AddPrerequisite(moduleID, prerequisiteID int64) error {
+	if moduleID == prerequisiteID {
+		return ErrPrerequisiteCycle
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize writers to the graph. Two concurrent inserts could each pass the cycle
+	// check on their own and form a cycle together.
+	_, err = tx.ExecContext(ctx, `LOCK TABLE module_prerequisites IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+WITH RECURSIVE required(id) AS (
+    SELECT prerequisite_id FROM module_prerequisites WHERE module_id = $1
+    UNION
+    SELECT mp.prerequisite_id
+    FROM module_prerequisites mp
+    INNER JOIN required ON mp.module_id = required.id
+)
+SELECT EXISTS(SELECT 1 FROM required WHERE id = $2)`
+	var cycle bool
+	err = tx.QueryRowContext(ctx, query, prerequisiteID, moduleID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrPrerequisiteCycle
+	}
+
+	query = `
+INSERT INTO module_prerequisites (module_id, prerequisite_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`
+	_, err = tx.ExecContext(ctx, query, moduleID, prerequisiteID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ModuleInfoModel) RemovePrerequisite(moduleID, prerequisiteID int64) error {
+	query := `
+DELETE FROM module_prerequisites
+WHERE module_id = $1 AND prerequisite_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, moduleID, prerequisiteID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// prerequisiteGraph loads every module reachable from the given modules by following
+// prerequisite edges, along with the edges themselves. Modules in the trash, and the
+// edges leading to them, are left out.
+func (m ModuleInfoModel) prerequisiteGraph(moduleIDs []int64) (map[int64]*ModuleInfo, map[int64][]int64, error) {
+	query := `
+WITH RECURSIVE edges(module_id, prerequisite_id) AS (
+    SELECT mp.module_id, mp.prerequisite_id
+    FROM module_prerequisites mp
+    WHERE mp.module_id = ANY($1)
+    UNION
+    SELECT mp.module_id, mp.prerequisite_id
+    FROM module_prerequisites mp
+    INNER JOIN edges ON mp.module_id = edges.prerequisite_id
+)
+SELECT edges.module_id, mi.id, mi.created_at, mi.updated_at, mi.module_name, mi.module_duration, mi.exam_type, mi.capacity, mi.version
+FROM edges
+INNER JOIN module_info mi ON mi.id = edges.prerequisite_id
+WHERE mi.deleted_at IS NULL
+UNION ALL
+SELECT NULL, mi.id, mi.created_at, mi.updated_at, mi.module_name, mi.module_duration, mi.exam_type, mi.capacity, mi.version
+FROM module_info mi
+WHERE mi.id = ANY($1) AND mi.deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(moduleIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	modules := make(map[int64]*ModuleInfo)
+	edges := make(map[int64][]int64)
+	for rows.Next() {
+		var requiredBy *int64
+		var module ModuleInfo
+		err := rows.Scan(
+			&requiredBy,
+			&module.ID,
+			&module.CreatedAt,
+			&module.UpdatedAt,
+			&module.ModuleName,
+			&module.ModuleDuration,
+			&module.ExamType,
+			&module.Capacity,
+			&module.Version,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		modules[module.ID] = &module
+		if requiredBy != nil {
+			edges[*requiredBy] = append(edges[*requiredBy], module.ID)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for id := range edges {
+		sort.Slice(edges[id], func(i, j int) bool { return edges[id][i] < edges[id][j] })
+	}
+	return modules, edges, nil
+}
+
+// PrerequisiteTree returns the full transitive prerequisite tree of a module, expanding
+// each module once in depth-first order.
+func (m ModuleInfoModel) PrerequisiteTree(moduleID int64) (*PrerequisiteNode, error) {
+	modules, edges, err := m.prerequisiteGraph([]int64{moduleID})
+	if err != nil {
+		return nil, err
+	}
+	if modules[moduleID] == nil {
+		return nil, ErrRecordNotFound
+	}
+	expanded := make(map[int64]bool)
+	var build func(id int64) *PrerequisiteNode
+	build = func(id int64) *PrerequisiteNode {
+		node := &PrerequisiteNode{Module: modules[id], Prerequisites: []*PrerequisiteNode{}}
+		if expanded[id] {
+			node.Repeated = true
+			return node
+		}
+		expanded[id] = true
+		for _, prerequisiteID := range edges[id] {
+			node.Prerequisites = append(node.Prerequisites, build(prerequisiteID))
+		}
+		return node
+	}
+	return build(moduleID), nil
+}
+
+// StudyOrder returns the given modules in an order in which they can be taken, so that
+// every module comes after all of its prerequisites among them, including the ones it
+// only requires transitively through modules outside of the set. Modules which don't
+// exist are reported with ErrRecordNotFound.
+func (m ModuleInfoModel) StudyOrder(moduleIDs []int64) ([]*ModuleInfo, error) {
+	modules, edges, err := m.prerequisiteGraph(moduleIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range moduleIDs {
+		if modules[id] == nil {
+			return nil, ErrRecordNotFound
+		}
+	}
+	order, err := topologicalOrder(moduleIDs, edges)
+	if err != nil {
+		return nil, err
+	}
+	requested := make(map[int64]bool, len(moduleIDs))
+	for _, id := range moduleIDs {
+		requested[id] = true
+	}
+	result := []*ModuleInfo{}
+	for _, id := range order {
+		if requested[id] {
+			result = append(result, modules[id])
+			delete(requested, id)
+		}
+	}
+	return result, nil
+}
+
+// topologicalOrder performs a depth-first search from each of the roots in ascending id
+// order, emitting every module after its prerequisites. The result covers all the
+// modules reachable from the roots, so that transitive constraints through modules
+// outside of the roots are respected too.
+func topologicalOrder(roots []int64, edges map[int64][]int64) ([]int64, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[int64]int)
+	order := []int64{}
+
+	var visit func(id int64) error
+	visit = func(id int64) error {
+		switch state[id] {
+		case visiting:
+			return ErrPrerequisiteCycle
+		case done:
+			return nil
+		}
+		state[id] = visiting
+		for _, prerequisiteID := range edges[id] {
+			if err := visit(prerequisiteID); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		order = append(order, id)
+		return nil
+	}
+
+	sorted := append([]int64(nil), roots...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, id := range sorted {
+		if err := visit(id); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
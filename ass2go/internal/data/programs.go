@@ -0,0 +1,344 @@
+package data
+
+import (
+	"ass2/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+var (
+	ErrUnknownProgramModule = errors.New("program references a module which does not exist")
+)
+
+var DegreeLevels = []string{"bachelor", "master", "doctorate"}
+
+// Program is a curriculum which groups modules into semesters. The modules within each
+// semester are kept in the order they were given in. Semesters are stored through their
+// modules, so a semester without any modules isn't kept.
+type Program struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Name        string      `json:"name"`
+	DegreeLevel string      `json:"degree_level"`
+	Semesters   []*Semester `json:"semesters"`
+	Version     int32       `json:"version"`
+}
+
+type Semester struct {
+	Number    int           `json:"number"`
+	ModuleIDs []int64       `json:"module_ids"`
+	Modules   []*ModuleInfo `json:"modules,omitempty"`
+}
+
+// SemesterLoad is the total duration of the modules in a semester, compared against the
+// most a student is expected to take on at once.
+type SemesterLoad struct {
+	Number        int  `json:"number"`
+	TotalDuration int  `json:"total_duration"`
+	Limit         int  `json:"limit"`
+	Overloaded    bool `json:"overloaded"`
+}
+
+// Loads works out the load of every semester of the program. It relies on the Modules of
+// each semester having been loaded, as Get() does.
+func (p *Program) Loads(limit int) []SemesterLoad {
+	loads := []SemesterLoad{}
+	for _, semester := range p.Semesters {
+		load := SemesterLoad{Number: semester.Number, Limit: limit}
+		for _, module := range semester.Modules {
+			load.TotalDuration += module.ModuleDuration
+		}
+		load.Overloaded = load.TotalDuration > limit
+		loads = append(loads, load)
+	}
+	return loads
+}
+
+func ValidateProgram(v *validator.Validator, program *Program) {
+	v.Check(program.Name != "", "name", "must be provided")
+	v.Check(len(program.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(program.DegreeLevel != "", "degree_level", "must be provided")
+	v.Check(program.DegreeLevel == "" || validator.PermittedValue(program.DegreeLevel, DegreeLevels...), "degree_level", "must be one of bachelor, master or doctorate")
+	v.Check(len(program.Semesters) <= 20, "semesters", "must not contain more than 20 semesters")
+
+	var numbers []int
+	var moduleIDs []int64
+	for _, semester := range program.Semesters {
+		v.Check(semester.Number >= 1 && semester.Number <= 20, "semesters", "semester numbers must be between 1 and 20")
+		numbers = append(numbers, semester.Number)
+		for _, id := range semester.ModuleIDs {
+			v.Check(id > 0, "semesters", "module ids must be positive integers")
+			moduleIDs = append(moduleIDs, id)
+		}
+	}
+	v.Check(validator.Unique(numbers), "semesters", "must not contain duplicate semester numbers")
+	v.Check(validator.Unique(moduleIDs), "semesters", "must not contain the same module more than once")
+}
+
+type ProgramModel struct {
+	DB *sql.DB
+}
+
+func (m ProgramModel) Insert(program *Program) error {
+	query := `
+INSERT INTO programs (name, degree_level)
+VALUES ($1, $2)
+RETURNING id, created_at, updated_at, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, program.Name, program.DegreeLevel).Scan(&program.ID, &program.CreatedAt, &program.UpdatedAt, &program.Version)
+	if err != nil {
+		return err
+	}
+	err = insertProgramModules(ctx, tx, program)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ProgramModel) Get(id int64) (*Program, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, updated_at, name, degree_level, version
+FROM programs
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var program Program
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&program.ID,
+		&program.CreatedAt,
+		&program.UpdatedAt,
+		&program.Name,
+		&program.DegreeLevel,
+		&program.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	err = m.loadSemesters(ctx, []*Program{&program})
+	if err != nil {
+		return nil, err
+	}
+	return &program, nil
+}
+
+func (m ProgramModel) GetAll(name, degreeLevel string, filters Filters) ([]*Program, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, updated_at, name, degree_level, version
+FROM programs
+WHERE (name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+AND (degree_level = $2 OR $2 = '')
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), degreeLevel, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	programs := []*Program{}
+	for rows.Next() {
+		var program Program
+		err := rows.Scan(
+			&totalRecords,
+			&program.ID,
+			&program.CreatedAt,
+			&program.UpdatedAt,
+			&program.Name,
+			&program.DegreeLevel,
+			&program.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		programs = append(programs, &program)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = m.loadSemesters(ctx, programs)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return programs, metadata, nil
+}
+
+// Update saves the program and replaces its semesters, provided that nobody else has
+// changed it since it was fetched.
+func (m ProgramModel) Update(program *Program) error {
+	query := `
+UPDATE programs
+SET name = $1, degree_level = $2, updated_at = NOW(), version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING updated_at, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	args := []any{program.Name, program.DegreeLevel, program.ID, program.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&program.UpdatedAt, &program.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM program_modules WHERE program_id = $1`, program.ID)
+	if err != nil {
+		return err
+	}
+	err = insertProgramModules(ctx, tx, program)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m ProgramModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+DELETE FROM programs
+WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// insertProgramModules writes out the semesters of a program. Every module must exist
+// and not be in the trash, otherwise ErrUnknownProgramModule is returned.
+func insertProgramModules(ctx context.Context, tx *sql.Tx, program *Program) error {
+	var moduleIDs []int64
+	for _, semester := range program.Semesters {
+		moduleIDs = append(moduleIDs, semester.ModuleIDs...)
+	}
+	if len(moduleIDs) == 0 {
+		return nil
+	}
+
+	var found int
+	query := `
+SELECT count(*)
+FROM module_info
+WHERE id = ANY($1) AND deleted_at IS NULL`
+	err := tx.QueryRowContext(ctx, query, pq.Array(moduleIDs)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(moduleIDs) {
+		return ErrUnknownProgramModule
+	}
+
+	query = `
+INSERT INTO program_modules (program_id, semester, position, module_id)
+VALUES ($1, $2, $3, $4)`
+	for _, semester := range program.Semesters {
+		for position, moduleID := range semester.ModuleIDs {
+			_, err := tx.ExecContext(ctx, query, program.ID, semester.Number, position, moduleID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSemesters fills in the semesters of the given programs, together with their
+// modules, using a single query. Modules in the trash are left out.
+func (m ProgramModel) loadSemesters(ctx context.Context, programs []*Program) error {
+	if len(programs) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Program, len(programs))
+	ids := make([]int64, 0, len(programs))
+	for _, program := range programs {
+		program.Semesters = []*Semester{}
+		byID[program.ID] = program
+		ids = append(ids, program.ID)
+	}
+
+	query := `
+SELECT pm.program_id, pm.semester, mi.id, mi.created_at, mi.updated_at, mi.module_name, mi.module_duration, mi.exam_type, mi.capacity, mi.version
+FROM program_modules pm
+INNER JOIN module_info mi ON mi.id = pm.module_id
+WHERE pm.program_id = ANY($1) AND mi.deleted_at IS NULL
+ORDER BY pm.program_id, pm.semester, pm.position`
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var programID int64
+		var number int
+		var module ModuleInfo
+		err := rows.Scan(
+			&programID,
+			&number,
+			&module.ID,
+			&module.CreatedAt,
+			&module.UpdatedAt,
+			&module.ModuleName,
+			&module.ModuleDuration,
+			&module.ExamType,
+			&module.Capacity,
+			&module.Version,
+		)
+		if err != nil {
+			return err
+		}
+		program := byID[programID]
+		semesters := program.Semesters
+		if len(semesters) == 0 || semesters[len(semesters)-1].Number != number {
+			program.Semesters = append(program.Semesters, &Semester{Number: number, ModuleIDs: []int64{}, Modules: []*ModuleInfo{}})
+		}
+		semester := program.Semesters[len(program.Semesters)-1]
+		semester.ModuleIDs = append(semester.ModuleIDs, module.ID)
+		semester.Modules = append(semester.Modules, &module)
+	}
+	return rows.Err()
+}
@@ -7,12 +7,23 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
 	"time"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	ScopeCalendar       = "calendar"       // Long-lived, read-only access to the user's calendar feed.
+	ScopeRefresh        = "refresh"        // Exchanged for a new authentication token when it expires.
+	ScopePasswordReset  = "password-reset" // Emailed to users who have forgotten their password.
+	ScopeEmailChange    = "email-change"   // Emailed to a new address to confirm that it belongs to the user.
+	ScopeTwoFactor      = "2fa-pending"    // Proves the password was right while the second factor is checked.
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 type Token struct {
@@ -21,6 +32,26 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    string    `json:"-"`
+}
+
+// TokenClient describes the client an authentication token was issued to, so that users
+// can tell their sessions apart.
+type TokenClient struct {
+	IPAddress string
+	UserAgent string
+}
+
+// Session is a token family as shown to its user, identified by the family itself.
+// Current is set for the family of the token the listing was requested with.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -29,17 +60,32 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
 	}
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	plaintext, err := randomString()
 	if err != nil {
 		return nil, err
 	}
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Plaintext = plaintext
+	token.Hash = HashTokenPlaintext(token.Plaintext)
 	return token, nil
 }
 
+// randomString returns 16 random bytes encoded as a 26 character base32 string.
+func randomString() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// HashTokenPlaintext returns the SHA-256 hash of a token, which is what is stored in the
+// database in place of the token itself.
+func HashTokenPlaintext(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -59,9 +105,9 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 }
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+INSERT INTO tokens (hash, user_id, expiry, scope, family)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''))`
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -77,3 +123,267 @@ WHERE scope = $1 AND user_id = $2`
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept is the same as DeleteAllForUser, except that it keeps the token
+// with the given hash and the rest of its token family, such as the session a user is
+// signed in with.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, keepHash []byte) error {
+	query := `
+DELETE FROM tokens
+WHERE scope = $1 AND user_id = $2 AND hash <> $3
+AND family IS DISTINCT FROM (SELECT family FROM tokens WHERE hash = $3)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, keepHash)
+	return err
+}
+
+// DeleteByHash deletes a single token, along with the rest of its token family if it
+// has one, so that the refresh token issued with an authentication token can't be used
+// to get a new one once it has been revoked.
+func (m TokenModel) DeleteByHash(hash []byte) error {
+	query := `
+DELETE FROM tokens
+WHERE hash = $1
+OR family = (SELECT family FROM tokens WHERE hash = $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// NewPair issues an authentication token together with a refresh token which can be
+// exchanged for a new pair once the authentication token expires. Both start a new token
+// family, which every pair issued by rotating the refresh token will also belong to.
+func (m TokenModel) NewPair(userID int64, client TokenClient, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	family, err := randomString()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, family, client, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// Rotate exchanges a refresh token for a new pair of tokens in the same family. The old
+// refresh token is marked as used rather than deleted, so that it is recognised if it
+// turns up again. Since a legitimate client never reuses a refresh token, that means it
+// has been stolen: every token in the family is then revoked, cutting off both the thief
+// and the legitimate client, and ErrRefreshTokenReused is returned.
+func (m TokenModel) Rotate(refreshPlaintext string, client TokenClient, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	hash := HashTokenPlaintext(refreshPlaintext)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+SELECT tokens.user_id, tokens.expiry, tokens.family, tokens.used_at IS NOT NULL
+FROM tokens
+INNER JOIN users ON users.id = tokens.user_id
+WHERE tokens.hash = $1 AND tokens.scope = $2 AND users.deleted_at IS NULL
+FOR UPDATE OF tokens`
+	var userID int64
+	var expiry time.Time
+	var family string
+	var used bool
+	err = tx.QueryRowContext(ctx, query, hash, ScopeRefresh).Scan(&userID, &expiry, &family, &used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	if used {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+	if !expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	access, refresh, err := insertTokenPair(ctx, tx, userID, family, client, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+func insertTokenPair(ctx context.Context, tx *sql.Tx, userID int64, family string, client TokenClient, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	query := `
+INSERT INTO tokens (hash, user_id, expiry, scope, family, ip_address, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, client.IPAddress, client.UserAgent}
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return access, refresh, nil
+}
+
+// DeleteExpired removes the tokens of every scope which have expired, including used
+// refresh tokens, which are only kept to detect reuse while they could still be
+// exchanged.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+DELETE FROM tokens
+WHERE expiry < NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetSessionsForUser returns the user's sessions, most recently used first. A session
+// is a token family: the authentication and refresh tokens issued at login and every
+// pair rotated from them since. It lasts as long as its refresh token, and was last
+// used when any of its authentication tokens was. currentHash is the hash of the token
+// making the request, whose family is flagged as the current session.
+func (m TokenModel) GetSessionsForUser(userID int64, currentHash []byte) ([]*Session, error) {
+	query := `
+SELECT family,
+       min(created_at),
+       max(last_used_at),
+       COALESCE(max(expiry) FILTER (WHERE scope = $3 AND used_at IS NULL), max(expiry)),
+       (array_agg(ip_address ORDER BY id DESC))[1],
+       (array_agg(user_agent ORDER BY id DESC))[1],
+       bool_or(hash = $4)
+FROM tokens
+WHERE user_id = $1 AND scope IN ($2, $3) AND family IS NOT NULL AND expiry > NOW()
+GROUP BY family
+ORDER BY COALESCE(max(last_used_at), min(created_at)) DESC, family`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, currentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession revokes one of the user's sessions, given the id that
+// GetSessionsForUser() lists it with, by deleting its whole token family.
+func (m TokenModel) DeleteSession(userID int64, id string) error {
+	query := `
+DELETE FROM tokens
+WHERE user_id = $1 AND family = $2 AND scope IN ($3, $4)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, id, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetLastUsed records when each of the given tokens, keyed by hash, was last used. It is
+// meant to be called with a batch of uses collected in memory, so that authenticating a
+// request doesn't cost a write.
+func (m TokenModel) SetLastUsed(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+	hashes := make([][]byte, 0, len(lastUsed))
+	times := make([]string, 0, len(lastUsed))
+	for hash, t := range lastUsed {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, t.UTC().Format(time.RFC3339))
+	}
+	query := `
+UPDATE tokens
+SET last_used_at = used.at
+FROM unnest($1::bytea[], $2::timestamptz[]) AS used(hash, at)
+WHERE tokens.hash = used.hash
+AND (tokens.last_used_at IS NULL OR tokens.last_used_at < used.at)`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.ByteaArray(hashes), pq.Array(times))
+	return err
+}
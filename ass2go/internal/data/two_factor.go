@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RecoveryCodeCount is the number of recovery codes issued when two-factor
+// authentication is enabled.
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotPending = errors.New("two-factor authentication not being set up")
+)
+
+// TwoFactor is the TOTP state of a user. Secret is set once the user has started
+// enrolling, but two-factor authentication is only required after the enrollment has
+// been confirmed with a valid code, which sets Enabled. LastStep is the time step of the
+// last code accepted, so that no code can be used twice.
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// GenerateRecoveryCodes returns a fresh set of recovery codes, formatted for display,
+// along with their hashes for storage.
+func GenerateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([][]byte, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random, err := randomString()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.Join([]string{random[0:4], random[4:8], random[8:12], random[12:16]}, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage or lookup. Codes are normalized
+// first, so that they can be typed in lower case and without the dashes.
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+func (m TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step
+FROM users
+WHERE id = $1 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var twoFactor TwoFactor
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// SetPendingSecret starts enrolling the user, replacing the secret of any earlier
+// enrollment which wasn't confirmed. It fails with ErrTwoFactorEnabled if two-factor
+// authentication is already enabled.
+func (m TwoFactorModel) SetPendingSecret(userID int64, secret string) error {
+	query := `
+UPDATE users
+SET totp_secret = $1
+WHERE id = $2 AND totp_enabled = false AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, secret, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// Enable confirms the enrollment, given the time step of the code it was confirmed with,
+// and stores the hashes of the user's recovery codes in place of any earlier ones.
+func (m TwoFactorModel) Enable(userID, step int64, recoveryCodeHashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE users
+SET totp_enabled = true, totp_last_step = $1
+WHERE id = $2 AND totp_enabled = false AND totp_secret IS NOT NULL AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTwoFactorNotPending
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	query = `
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2)`
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, query, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Disable turns two-factor authentication off and forgets the secret and recovery codes.
+func (m TwoFactorModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE users
+SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0
+WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep records that a code from the given time step has been accepted. It reports
+// false if a code from that step or a later one was already accepted, in which case the
+// code must be rejected as a replay. The check and update are a single statement, so
+// that two requests can't both use the same code.
+func (m TwoFactorModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+UPDATE users
+SET totp_last_step = $1
+WHERE id = $2 AND totp_last_step < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. It reports false if
+// the code doesn't exist or has already been used.
+func (m TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -6,7 +6,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
 	"time"
 )
 
@@ -20,16 +23,17 @@ func (u *User) IsAnonymous() bool {
 }
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Fname     string    `json:"fname"`
-	Sname     string    `json:"sname"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	Password  password  `json:"password"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Fname     string     `json:"fname"`
+	Sname     string     `json:"sname"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	Password  password   `json:"password"`
+	Activated bool       `json:"activated"`
+	Version   int        `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 type UserModel struct {
 	DB *sql.DB
@@ -40,7 +44,9 @@ type password struct {
 	hash      []byte
 }
 
-func (m UserModel) Insert(user *User) error {
+// Insert creates the user together with the permissions with the given codes, in one
+// transaction so that a user is never left without the permissions they start with.
+func (m UserModel) Insert(user *User, permissionCodes ...string) error {
 	query := `
 INSERT INTO users (fname, sname,role, email, password_hash, activated)
 VALUES ($1, $2, 'user', $3, $4, false)
@@ -48,7 +54,13 @@ RETURNING id, created_at, version`
 	args := []any{user.Fname, user.Sname, user.Email, user.Password.hash}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -57,14 +69,21 @@ RETURNING id, created_at, version`
 			return err
 		}
 	}
-	return nil
+	query = `
+INSERT INTO users_permissions
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(permissionCodes))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 SELECT id, created_at, updated_at, fname, sname, email, role, password_hash, activated, version
 FROM users
-WHERE email = $1`
+WHERE email = $1 AND deleted_at IS NULL`
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -91,11 +110,60 @@ WHERE email = $1`
 	return &user, nil
 }
 
+// SetPendingEmail records the address the user wants to change their email to. It only
+// replaces the current one once ConfirmPendingEmail() is called.
+func (m UserModel) SetPendingEmail(id int64, email string) error {
+	query := `
+UPDATE users
+SET pending_email = $1
+WHERE id = $2 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, email, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ConfirmPendingEmail makes the user's pending email address their email address, and
+// updates the user with the new address and version. ErrRecordNotFound is returned if
+// there is no pending address, and ErrDuplicateEmail if another user has taken it since
+// it was requested.
+func (m UserModel) ConfirmPendingEmail(user *User) error {
+	query := `
+UPDATE users
+SET email = pending_email, pending_email = NULL, updated_at = NOW(), version = version + 1
+WHERE id = $1 AND pending_email IS NOT NULL AND deleted_at IS NULL
+RETURNING email, updated_at, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Email, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
 SET fname = $1,sname = $2, updated_at = $3,email = $4, password_hash = $5, activated = $6, version = version + 1
-WHERE id = $7 AND version = $8
+WHERE id = $7 AND version = $8 AND deleted_at IS NULL
 RETURNING version`
 	args := []any{
 		user.Fname,
@@ -132,7 +200,8 @@ INNER JOIN tokens
 ON users.id = tokens.user_id
 WHERE tokens.hash = $1
 AND tokens.scope = $2
-AND tokens.expiry > $3`
+AND tokens.expiry > $3
+AND users.deleted_at IS NULL`
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -165,9 +234,9 @@ func (m UserModel) Get(id int64) (*User, error) {
 	}
 
 	query := `
-			SELECT *
-			FROM users
-			WHERE id = $1`
+SELECT id, created_at, updated_at, fname, sname, email, password_hash, role, activated, version
+FROM users
+WHERE id = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -194,12 +263,74 @@ func (m UserModel) Get(id int64) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) GetAll() ([]*User, error) {
-	query := `SELECT id, created_at, updated_at, fname, sname, email, password_hash, role, activated, version FROM users`
+func (m UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, updated_at, fname, sname, email, password_hash, role, activated, version
+FROM users
+WHERE deleted_at IS NULL
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	userInfos := []*User{}
+	for rows.Next() {
+		userInfo := &User{}
+		err = rows.Scan(
+			&totalRecords,
+			&userInfo.ID,
+			&userInfo.CreatedAt,
+			&userInfo.UpdatedAt,
+			&userInfo.Fname,
+			&userInfo.Sname,
+			&userInfo.Email,
+			&userInfo.Password.hash,
+			&userInfo.Role,
+			&userInfo.Activated,
+			&userInfo.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		userInfos = append(userInfos, userInfo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if len(userInfos) > 0 {
+		last := userInfos[len(userInfos)-1]
+		metadata.NextCursor = nextCursor(filters, len(userInfos), last.sortValue(filters.sortColumn()), last.ID)
+	}
+	return userInfos, metadata, nil
+}
+
+// GetAllAfterCursor returns the page of users following filters.Cursor, using keyset
+// pagination on the (sort column, id) pair.
+func (m UserModel) GetAllAfterCursor(filters Filters) ([]*User, Metadata, error) {
+	cursor := filters.cursor()
+	query := fmt.Sprintf(`
+SELECT id, created_at, updated_at, fname, sname, email, password_hash, role, activated, version
+FROM users
+WHERE (%[1]s, id) %[3]s ($1, $2)
+AND deleted_at IS NULL
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $3`, filters.sortColumn(), filters.sortDirection(), filters.cursorComparator())
 
-	rows, err := m.DB.Query(query)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, cursor.Value, cursor.ID, filters.limit())
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
@@ -219,25 +350,51 @@ func (m UserModel) GetAll() ([]*User, error) {
 			&userInfo.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		userInfos = append(userInfos, userInfo)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	var metadata Metadata
+	if len(userInfos) > 0 {
+		last := userInfos[len(userInfos)-1]
+		metadata = calculateCursorMetadata(filters, len(userInfos), last.sortValue(filters.sortColumn()), last.ID)
 	}
+	return userInfos, metadata, nil
+}
 
-	return userInfos, nil
+// sortValue returns the value of the given sort column for the user, formatted so that
+// PostgreSQL can compare it against the column when it is passed back in a cursor.
+func (u *User) sortValue(column string) string {
+	switch column {
+	case "fname":
+		return u.Fname
+	case "sname":
+		return u.Sname
+	case "email":
+		return u.Email
+	case "created_at":
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return strconv.FormatInt(u.ID, 10)
+	}
 }
 
+// Delete moves a user to the trash by setting their deleted_at timestamp. Their tokens
+// and permissions are kept, so that restoring the account brings them back as well, but
+// their email address is free to be used by someone else in the meantime.
 func (m UserModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
-		DELETE FROM users
-		WHERE id = $1`
+		UPDATE users
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`
 	result, err := m.DB.Exec(query, id)
 	if err != nil {
 		return err
@@ -252,6 +409,108 @@ func (m UserModel) Delete(id int64) error {
 	return nil
 }
 
+// GetAllDeleted returns the users who are currently in the trash.
+func (m UserModel) GetAllDeleted(filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, updated_at, fname, sname, email, role, activated, version, deleted_at
+FROM users
+WHERE deleted_at IS NOT NULL
+ORDER BY %[1]s %[2]s, id %[2]s
+LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	userInfos := []*User{}
+	for rows.Next() {
+		userInfo := &User{}
+		err = rows.Scan(
+			&totalRecords,
+			&userInfo.ID,
+			&userInfo.CreatedAt,
+			&userInfo.UpdatedAt,
+			&userInfo.Fname,
+			&userInfo.Sname,
+			&userInfo.Email,
+			&userInfo.Role,
+			&userInfo.Activated,
+			&userInfo.Version,
+			&userInfo.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		userInfos = append(userInfos, userInfo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return userInfos, metadata, nil
+}
+
+// Restore takes a user back out of the trash. ErrDuplicateEmail is returned if another
+// user has taken their email address while they were in it.
+func (m UserModel) Restore(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, created_at, updated_at, fname, sname, email, role, activated, version`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Fname,
+		&user.Sname,
+		&user.Email,
+		&user.Role,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return nil, ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Purge permanently deletes the users who have been in the trash for longer than the
+// retention period, and returns how many there were. Their tokens and permissions go
+// with them through ON DELETE CASCADE.
+func (m UserModel) Purge(retention time.Duration) (int64, error) {
+	query := `
+DELETE FROM users
+WHERE deleted_at < $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (p *password) Set(plaintextPassword string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
 	if err != nil {
@@ -295,33 +554,66 @@ func ValidateUser(v *validator.Validator, user *User) {
 		panic("missing password hash for user")
 	}
 }
-func (m UserModel) FindNotActivatedAndExpired() ([]*User, error) {
+
+// DeleteUnactivated moves the accounts which were registered more than maxAge ago but
+// never activated, and which don't have an unexpired activation token either, to the
+// trash. This frees up their email addresses to be registered again, and Purge() removes
+// them for good once the retention period is over, as with any other deleted user.
+func (m UserModel) DeleteUnactivated(maxAge time.Duration) (int64, error) {
 	query := `
-      SELECT u.*
-      FROM users u
-      INNER JOIN public.tokens uit on u.id = uit.user_id
-      WHERE u.activated = false AND uit.expiry < now() - interval '10 s'
-`
+UPDATE users
+SET deleted_at = NOW()
+WHERE activated = false
+AND deleted_at IS NULL
+AND created_at < $1
+AND NOT EXISTS (
+    SELECT 1
+    FROM tokens
+    WHERE tokens.user_id = users.id AND tokens.scope = 'activation' AND tokens.expiry > NOW()
+)`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
 
-	rows, err := m.DB.Query(query)
+// Export calls fn for every user, in id order, while reading them from the database, in
+// the same way as ModuleInfoModel.Export(). The password hash isn't read, so it can't
+// end up in an export by mistake.
+func (m UserModel) Export(ctx context.Context, fn func(*User) error) error {
+	query := `
+SELECT id, created_at, updated_at, fname, sname, role, email, activated, version
+FROM users
+WHERE deleted_at IS NULL
+ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var users []*User
+	var user User
 	for rows.Next() {
-		var user User
-		err = rows.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Fname, &user.Sname, &user.Email, &user.Password.hash, &user.Role, &user.Activated, &user.Version)
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Fname,
+			&user.Sname,
+			&user.Role,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
 		}
-		users = append(users, &user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
 	}
-
-	return users, nil
+	return rows.Err()
 }
@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from RFC 6238 Appendix B. The RFC gives 8 digit codes, of
+// which ours are the last 6.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+// rfc6238Secret is the RFC's SHA-1 seed, the ASCII string "12345678901234567890",
+// encoded as a secret.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		got, err := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code() at %d: unexpected error: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("Code() at %d = %q, want %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "287082" {
+		t.Errorf("got %q, want %q", got, "287082")
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
+
+func TestCodeEmptySecret(t *testing.T) {
+	_, err := Code("", 1)
+	if !errors.Is(err, ErrEmptySecret) {
+		t.Errorf("Code() error = %v, want %v", err, ErrEmptySecret)
+	}
+	// 812658 is what HMAC with an empty key gives for step 1.
+	if _, ok := Validate("", "812658", time.Unix(30, 0)); ok {
+		t.Error("Validate() accepted a code for an empty secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		at := time.Unix(tt.unix, 0)
+		step, ok := Validate(rfc6238Secret, tt.code, at)
+		if !ok {
+			t.Errorf("Validate() at %d rejected %q", tt.unix, tt.code)
+			continue
+		}
+		if step != Step(at) {
+			t.Errorf("Validate() at %d returned step %d, want %d", tt.unix, step, Step(at))
+		}
+	}
+}
+
+func TestValidateDrift(t *testing.T) {
+	// 1111111111 falls in step 37037037. A code generated in that step is accepted one step
+	// either side of it and no further.
+	code := "050471"
+	codeStep := Step(time.Unix(1111111111, 0))
+	tests := []struct {
+		name   string
+		offset time.Duration
+		valid  bool
+	}{
+		{"two steps early", -2 * Period, false},
+		{"one step early", -Period, true},
+		{"same step", 0, true},
+		{"one step late", Period, true},
+		{"two steps late", 2 * Period, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := time.Unix(1111111111, 0).Add(tt.offset)
+			step, ok := Validate(rfc6238Secret, code, at)
+			if ok != tt.valid {
+				t.Fatalf("Validate() ok = %t, want %t", ok, tt.valid)
+			}
+			if ok && step != codeStep {
+				t.Errorf("Validate() step = %d, want %d", step, codeStep)
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := Validate(rfc6238Secret, code, at); ok {
+			t.Errorf("Validate() accepted %q", code)
+		}
+	}
+	if _, ok := Validate(rfc6238Secret, " 287082 ", at); !ok {
+		t.Error("Validate() rejected a code with surrounding whitespace")
+	}
+}
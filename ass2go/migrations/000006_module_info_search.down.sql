@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS module_info_search_idx;
+DROP TRIGGER IF EXISTS module_info_search_update ON module_info;
+DROP FUNCTION IF EXISTS module_info_search_update();
+ALTER TABLE module_info
+    DROP COLUMN IF EXISTS search;
@@ -0,0 +1,24 @@
+ALTER TABLE module_info
+    ADD COLUMN IF NOT EXISTS search tsvector;
+
+CREATE OR REPLACE FUNCTION module_info_search_update() RETURNS trigger AS
+$$
+BEGIN
+    NEW.search :=
+                setweight(to_tsvector('english', coalesce(NEW.module_name, '')), 'A') ||
+                setweight(to_tsvector('english', coalesce(NEW.exam_type, '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER module_info_search_update
+    BEFORE INSERT OR UPDATE OF module_name, exam_type
+    ON module_info
+    FOR EACH ROW
+EXECUTE FUNCTION module_info_search_update();
+
+UPDATE module_info
+SET search = setweight(to_tsvector('english', coalesce(module_name, '')), 'A') ||
+             setweight(to_tsvector('english', coalesce(exam_type, '')), 'B');
+
+CREATE INDEX IF NOT EXISTS module_info_search_idx ON module_info USING GIN (search);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS module_info_deleted_at_idx;
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE module_info
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS module_info_history
+(
+    id             BIGSERIAL PRIMARY KEY,
+    module_info_id BIGINT                      NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    user_id        BIGINT                      REFERENCES users ON DELETE SET NULL,
+    action         TEXT                        NOT NULL,
+    version        INTEGER                     NOT NULL,
+    before         JSONB,
+    after          JSONB,
+    changed_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS module_info_history_module_info_id_idx ON module_info_history (module_info_id, id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS module_prerequisites
+(
+    module_id       BIGINT NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    prerequisite_id BIGINT NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    PRIMARY KEY (module_id, prerequisite_id),
+    CONSTRAINT check_not_self_prerequisite CHECK (module_id <> prerequisite_id)
+);
+
+CREATE INDEX IF NOT EXISTS module_prerequisites_prerequisite_id_idx ON module_prerequisites (prerequisite_id);
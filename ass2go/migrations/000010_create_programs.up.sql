@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS programs
+(
+    id           BIGSERIAL PRIMARY KEY,
+    created_at   TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name         VARCHAR(500)                NOT NULL,
+    degree_level TEXT                        NOT NULL,
+    version      INTEGER                     NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS program_modules
+(
+    program_id BIGINT  NOT NULL REFERENCES programs ON DELETE CASCADE,
+    semester   INTEGER NOT NULL,
+    position   INTEGER NOT NULL,
+    module_id  BIGINT  NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    PRIMARY KEY (program_id, semester, position),
+    UNIQUE (program_id, module_id),
+    CONSTRAINT check_semester CHECK (semester BETWEEN 1 AND 20)
+);
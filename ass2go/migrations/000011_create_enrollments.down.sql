@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS enrollments;
+ALTER TABLE module_info
+    DROP CONSTRAINT IF EXISTS check_capacity,
+    DROP COLUMN IF EXISTS capacity;
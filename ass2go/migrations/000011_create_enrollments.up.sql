@@ -0,0 +1,17 @@
+ALTER TABLE module_info
+    ADD COLUMN IF NOT EXISTS capacity INTEGER NOT NULL DEFAULT 30,
+    ADD CONSTRAINT check_capacity CHECK (capacity > 0);
+
+CREATE TABLE IF NOT EXISTS enrollments
+(
+    id             BIGSERIAL PRIMARY KEY,
+    module_info_id BIGINT                      NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    user_id        BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    status         TEXT                        NOT NULL,
+    created_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (module_info_id, user_id),
+    CONSTRAINT check_status CHECK (status IN ('enrolled', 'waitlisted'))
+);
+
+CREATE INDEX IF NOT EXISTS enrollments_user_id_idx ON enrollments (user_id);
@@ -0,0 +1,19 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE IF NOT EXISTS exam_sessions
+(
+    id             BIGSERIAL PRIMARY KEY,
+    created_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    module_info_id BIGINT                      NOT NULL REFERENCES module_info ON DELETE CASCADE,
+    starts_at      TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    ends_at        TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    room           TEXT                        NOT NULL,
+    proctor_id     BIGINT                      NOT NULL REFERENCES users,
+    version        INTEGER                     NOT NULL DEFAULT 1,
+    CONSTRAINT check_ends_after_starts CHECK (ends_at > starts_at),
+    CONSTRAINT exam_sessions_room_overlap EXCLUDE USING gist (room WITH =, tstzrange(starts_at, ends_at) WITH &&)
+);
+
+CREATE INDEX IF NOT EXISTS exam_sessions_module_info_id_idx ON exam_sessions (module_info_id);
+CREATE INDEX IF NOT EXISTS exam_sessions_starts_at_idx ON exam_sessions (starts_at);
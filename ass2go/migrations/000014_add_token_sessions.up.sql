@@ -0,0 +1,6 @@
+ALTER TABLE tokens
+    ADD COLUMN id           bigserial UNIQUE,
+    ADD COLUMN created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN last_used_at timestamp(0) with time zone NULL,
+    ADD COLUMN ip_address   text                        NOT NULL DEFAULT '',
+    ADD COLUMN user_agent   text                        NOT NULL DEFAULT '';
@@ -0,0 +1,14 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret    text    NULL,
+    ADD COLUMN totp_enabled   boolean NOT NULL DEFAULT false,
+    ADD COLUMN totp_last_step bigint  NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id         bigserial PRIMARY KEY,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    code_hash  bytea                       NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    used_at    timestamp(0) with time zone NULL,
+    UNIQUE (user_id, code_hash)
+);
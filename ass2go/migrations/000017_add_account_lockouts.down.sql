@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS account_lockouts;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS locked_until,
+    DROP COLUMN IF EXISTS lockouts,
+    DROP COLUMN IF EXISTS failed_logins;
@@ -0,0 +1,18 @@
+ALTER TABLE users
+    ADD COLUMN failed_logins integer                     NOT NULL DEFAULT 0,
+    ADD COLUMN lockouts      integer                     NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until  timestamp(0) with time zone NULL;
+
+CREATE TABLE IF NOT EXISTS account_lockouts
+(
+    id              bigserial PRIMARY KEY,
+    user_id         bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until    timestamp(0) with time zone NOT NULL,
+    failed_attempts integer                     NOT NULL,
+    ip_address      text                        NOT NULL DEFAULT '',
+    unlocked_at     timestamp(0) with time zone NULL,
+    unlocked_by     bigint                      NULL REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS account_lockouts_user_id_idx ON account_lockouts (user_id);
@@ -0,0 +1,11 @@
+-- Take back the module grants the up migration gave out. They can't be told apart from
+-- the same grants made by hand before it ran, which go as well.
+DELETE FROM users_permissions
+USING users, permissions
+WHERE users_permissions.user_id = users.id
+AND users_permissions.permission_id = permissions.id
+AND (permissions.code = 'info:read' OR (permissions.code = 'info:write' AND users.role = 'admin'));
+
+-- The users:* grants go with the permissions through ON DELETE CASCADE.
+DELETE FROM permissions
+WHERE code IN ('users:read', 'users:write');
@@ -0,0 +1,21 @@
+INSERT INTO permissions (code)
+SELECT code
+FROM (VALUES ('info:read'), ('info:write'), ('users:read'), ('users:write')) AS new (code)
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE permissions.code = new.code);
+
+-- Admins keep everything they could do under the old role check.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id
+FROM users
+CROSS JOIN permissions
+WHERE users.role = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- Everyone else gets the permission new users are registered with, so that they can
+-- still read modules.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id
+FROM users
+CROSS JOIN permissions
+WHERE permissions.code = 'info:read'
+ON CONFLICT DO NOTHING;
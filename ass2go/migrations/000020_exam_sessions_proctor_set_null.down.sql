@@ -0,0 +1,7 @@
+DELETE FROM exam_sessions
+WHERE proctor_id IS NULL;
+
+ALTER TABLE exam_sessions
+    DROP CONSTRAINT IF EXISTS exam_sessions_proctor_id_fkey,
+    ADD CONSTRAINT exam_sessions_proctor_id_fkey FOREIGN KEY (proctor_id) REFERENCES users,
+    ALTER COLUMN proctor_id SET NOT NULL;
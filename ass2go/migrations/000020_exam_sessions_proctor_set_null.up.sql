@@ -0,0 +1,6 @@
+-- A proctor can be purged from the users table while their exam sessions remain, in
+-- which case the sessions are left without a proctor until one is assigned again.
+ALTER TABLE exam_sessions
+    ALTER COLUMN proctor_id DROP NOT NULL,
+    DROP CONSTRAINT IF EXISTS exam_sessions_proctor_id_fkey,
+    ADD CONSTRAINT exam_sessions_proctor_id_fkey FOREIGN KEY (proctor_id) REFERENCES users ON DELETE SET NULL;
@@ -0,0 +1,6 @@
+DELETE FROM module_info_history
+WHERE module_info_id NOT IN (SELECT id FROM module_info);
+
+ALTER TABLE module_info_history
+    DROP CONSTRAINT IF EXISTS module_info_history_module_info_id_fkey,
+    ADD CONSTRAINT module_info_history_module_info_id_fkey FOREIGN KEY (module_info_id) REFERENCES module_info ON DELETE CASCADE;
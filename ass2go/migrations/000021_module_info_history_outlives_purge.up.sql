@@ -0,0 +1,5 @@
+-- The history of a module is its audit trail, including who deleted it, so it is kept
+-- when the module itself is purged from the trash. module_info_id stays indexed by
+-- module_info_history_module_info_id_idx.
+ALTER TABLE module_info_history
+    DROP CONSTRAINT IF EXISTS module_info_history_module_info_id_fkey;
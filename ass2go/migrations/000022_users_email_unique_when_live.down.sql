@@ -0,0 +1,10 @@
+-- Users in the trash whose address has been taken by someone else can't be restored
+-- anyway, and would break the constraint.
+DELETE FROM users AS trashed
+WHERE trashed.deleted_at IS NOT NULL
+AND EXISTS (SELECT 1 FROM users WHERE users.email = trashed.email AND users.id <> trashed.id);
+
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
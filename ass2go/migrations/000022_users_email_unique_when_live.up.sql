@@ -0,0 +1,7 @@
+-- An email address only has to be unique among the users who aren't in the trash, so
+-- that it can be registered again once its owner has been deleted. The index keeps the
+-- name of the constraint it replaces, which is what duplicate emails are detected by.
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+-- Sessions of modules in the trash whose room has been booked again in the meantime
+-- would break the constraint.
+DELETE FROM exam_sessions AS trashed
+WHERE trashed.module_deleted
+AND EXISTS (
+    SELECT 1 FROM exam_sessions
+    WHERE exam_sessions.id <> trashed.id AND exam_sessions.room = trashed.room
+    AND tstzrange(exam_sessions.starts_at, exam_sessions.ends_at) && tstzrange(trashed.starts_at, trashed.ends_at)
+);
+
+ALTER TABLE exam_sessions
+    DROP CONSTRAINT IF EXISTS exam_sessions_room_overlap,
+    ADD CONSTRAINT exam_sessions_room_overlap EXCLUDE USING gist (room WITH =, tstzrange(starts_at, ends_at) WITH &&),
+    DROP COLUMN IF EXISTS module_deleted;
@@ -0,0 +1,13 @@
+-- Sessions of a module in the trash don't hold on to their room, so that it can be
+-- booked again. The flag mirrors module_info.deleted_at, since an exclusion constraint
+-- can only look at the row itself.
+ALTER TABLE exam_sessions
+    ADD COLUMN module_deleted BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE exam_sessions
+SET module_deleted = true
+WHERE module_info_id IN (SELECT id FROM module_info WHERE deleted_at IS NOT NULL);
+
+ALTER TABLE exam_sessions
+    DROP CONSTRAINT IF EXISTS exam_sessions_room_overlap,
+    ADD CONSTRAINT exam_sessions_room_overlap EXCLUDE USING gist (room WITH =, tstzrange(starts_at, ends_at) WITH &&) WHERE (NOT module_deleted);